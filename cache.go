@@ -0,0 +1,322 @@
+package gotomic
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+/*
+ CacheEvictor is called with the key and value of each entry a Cache evicts or expires.
+*/
+type CacheEvictor func(k Hashable, v Thing)
+
+/*
+ CacheStats contains the counters of a Cache.
+*/
+type CacheStats struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+}
+
+type cacheEntry struct {
+	/*
+	 Unix nanoseconds when this entry expires, or 0 if it never does.
+	*/
+	expires int64
+	/*
+	 Will point to a Thing.
+	*/
+	value unsafe.Pointer
+	key   Hashable
+	/*
+	 Set when the entry is used, and cleared when the clock hand passes it.
+	*/
+	referenced int32
+	/*
+	 Set when the entry is evicted, expired or deleted.
+	*/
+	dead int32
+}
+
+func (self *cacheEntry) val() Thing {
+	return *(*Thing)(atomic.LoadPointer(&self.value))
+}
+func (self *cacheEntry) isDead() bool {
+	return atomic.LoadInt32(&self.dead) == 1
+}
+func (self *cacheEntry) expired(now int64) bool {
+	expires := atomic.LoadInt64(&self.expires)
+	return expires != 0 && expires <= now
+}
+func (self *cacheEntry) String() string {
+	return fmt.Sprintf("&cacheEntry{%v=>%v}", self.key, self.val())
+}
+
+/*
+ Cache is a Hash with expiring entries and an optional maximum size.
+
+ Entries that outlive their TTL are expired when they are found by Get, Put, Purge or the clock hand.
+
+ When the Cache has a maximum size it uses the CLOCK algorithm to choose entries to evict: each entry is
+ kept in a slot in a ring, and gets its referenced flag set when used. A new entry will move the clock hand
+ around the ring, clearing referenced flags, until it finds a slot that is empty, expired or not referenced.
+
+ It is thread safe and non-blocking, but the size may temporarily exceed the maximum when new entries race
+ with each other, and updates racing with the eviction of the same entry may be lost.
+*/
+type Cache struct {
+	hits        int64
+	misses      int64
+	evictions   int64
+	expirations int64
+	hand        uint64
+	ttl         int64
+	hash        *Hash
+	/*
+	 Will point to *cacheEntry, or be nil if the Cache has no maximum size.
+	*/
+	slots   []unsafe.Pointer
+	onEvict CacheEvictor
+}
+
+/*
+ NewCache returns a Cache keeping at most capacity entries (or any number, if capacity is 0) that expire
+ after ttl (or never, if ttl is 0), calling onEvict (unless nil) for each entry it evicts or expires.
+*/
+func NewCache(capacity int, ttl time.Duration, onEvict CacheEvictor) *Cache {
+	rval := &Cache{
+		ttl:     int64(ttl),
+		hash:    NewHash(),
+		onEvict: onEvict,
+	}
+	if capacity > 0 {
+		rval.slots = make([]unsafe.Pointer, capacity)
+	}
+	return rval
+}
+
+/*
+ Size returns the number of entries in the Cache, including expired entries that haven't been found yet.
+*/
+func (self *Cache) Size() int {
+	return self.hash.Size()
+}
+
+/*
+ Stats returns the current hit, miss, eviction and expiration counters.
+*/
+func (self *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:        atomic.LoadInt64(&self.hits),
+		Misses:      atomic.LoadInt64(&self.misses),
+		Evictions:   atomic.LoadInt64(&self.evictions),
+		Expirations: atomic.LoadInt64(&self.expirations),
+	}
+}
+
+/*
+ kill marks ce as dead and removes it from the Hash, and returns whether we were the ones to do it.
+*/
+func (self *Cache) kill(ce *cacheEntry) bool {
+	if atomic.CompareAndSwapInt32(&ce.dead, 0, 1) {
+		self.hash.deleteIfIdentical(ce.key.HashCode(), ce.key, ce)
+		return true
+	}
+	return false
+}
+func (self *Cache) evict(ce *cacheEntry) {
+	if self.kill(ce) {
+		atomic.AddInt64(&self.evictions, 1)
+		if self.onEvict != nil {
+			self.onEvict(ce.key, ce.val())
+		}
+	}
+}
+func (self *Cache) expire(ce *cacheEntry) {
+	if self.kill(ce) {
+		atomic.AddInt64(&self.expirations, 1)
+		if self.onEvict != nil {
+			self.onEvict(ce.key, ce.val())
+		}
+	}
+}
+
+/*
+ admit will find a slot in the clock ring for ce, evicting whatever it has to.
+
+ As long as the Cache isn't over capacity there will be a free slot somewhere, so we only evict entries when it is.
+ If ce dies before it finds a slot the Cache may not be over capacity any more, so then we give up.
+*/
+func (self *Cache) admit(ce *cacheEntry) {
+	if self.slots == nil {
+		return
+	}
+	n := uint64(len(self.slots))
+	for !ce.isDead() {
+		index := atomic.AddUint64(&self.hand, 1) % n
+		current := atomic.LoadPointer(&self.slots[index])
+		old := (*cacheEntry)(current)
+		if old == nil || old.isDead() {
+			if atomic.CompareAndSwapPointer(&self.slots[index], current, unsafe.Pointer(ce)) {
+				return
+			}
+		} else if old.expired(time.Now().UnixNano()) {
+			if atomic.CompareAndSwapPointer(&self.slots[index], current, unsafe.Pointer(ce)) {
+				self.expire(old)
+				return
+			}
+		} else if uint64(self.hash.Size()) > n && !atomic.CompareAndSwapInt32(&old.referenced, 1, 0) {
+			if atomic.CompareAndSwapPointer(&self.slots[index], current, unsafe.Pointer(ce)) {
+				self.evict(old)
+				return
+			}
+		}
+	}
+}
+
+/*
+ Get returns the value at k and whether it was present (and not expired) in the Cache.
+*/
+func (self *Cache) Get(k Hashable) (Thing, bool) {
+	if e := self.hash.findEntry(k.HashCode(), k); e != nil {
+		ce := e.val().(*cacheEntry)
+		if !ce.isDead() {
+			if !ce.expired(time.Now().UnixNano()) {
+				atomic.StoreInt32(&ce.referenced, 1)
+				atomic.AddInt64(&self.hits, 1)
+				return ce.val(), true
+			}
+			self.expire(ce)
+		}
+	}
+	atomic.AddInt64(&self.misses, 1)
+	return nil, false
+}
+
+/*
+ Put k and v in the Cache using the default TTL of the Cache.
+*/
+func (self *Cache) Put(k Hashable, v Thing) {
+	self.PutTTL(k, v, time.Duration(self.ttl))
+}
+
+/*
+ PutTTL will put k and v in the Cache, and make them expire after ttl (or never, if ttl is 0).
+*/
+func (self *Cache) PutTTL(k Hashable, v Thing, ttl time.Duration) {
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().UnixNano() + int64(ttl)
+	}
+	hashCode := k.HashCode()
+	for {
+		if e := self.hash.findEntry(hashCode, k); e != nil {
+			ce := e.val().(*cacheEntry)
+			if !ce.isDead() {
+				atomic.StorePointer(&ce.value, unsafe.Pointer(&v))
+				atomic.StoreInt64(&ce.expires, expires)
+				atomic.StoreInt32(&ce.referenced, 1)
+				return
+			}
+			/*
+			 Help whoever killed it to remove it before we try again.
+			*/
+			self.hash.deleteIfIdentical(hashCode, k, ce)
+		} else {
+			ce := &cacheEntry{expires: expires, value: unsafe.Pointer(&v), key: k}
+			if self.hash.putIfMissing(newRealEntryWithHashCode(k, ce, hashCode)) {
+				self.admit(ce)
+				return
+			}
+		}
+	}
+}
+
+/*
+ Delete removes k from the Cache and returns any value it removed.
+
+ Deleted entries are not passed to the CacheEvictor.
+*/
+func (self *Cache) Delete(k Hashable) (Thing, bool) {
+	if e := self.hash.findEntry(k.HashCode(), k); e != nil {
+		ce := e.val().(*cacheEntry)
+		if ce.expired(time.Now().UnixNano()) {
+			self.expire(ce)
+		} else if self.kill(ce) {
+			return ce.val(), true
+		}
+	}
+	return nil, false
+}
+
+/*
+ Clear removes all entries from the Cache without passing them to the CacheEvictor.
+
+ Like Hash#Clear it will replace the contents of the Cache, so concurrent readers will see either the old or the empty contents.
+*/
+func (self *Cache) Clear() {
+	self.hash.Clear()
+	self.clearSlots()
+}
+
+/*
+ clearSlots marks the entries in the slots dead, so that they can be replaced, unless they were put after the Hash was cleared.
+*/
+func (self *Cache) clearSlots() {
+	for index := range self.slots {
+		if ce := (*cacheEntry)(atomic.LoadPointer(&self.slots[index])); ce != nil {
+			if e := self.hash.findEntry(ce.key.HashCode(), ce.key); e == nil || e.val() != ce {
+				atomic.StoreInt32(&ce.dead, 1)
+			}
+		}
+	}
+}
+
+/*
+ Purge expires all entries that have outlived their TTL, and returns the number of entries it expired.
+*/
+func (self *Cache) Purge() (rval int) {
+	now := time.Now().UnixNano()
+	self.hash.Each(func(k Hashable, v Thing) bool {
+		if ce := v.(*cacheEntry); ce.expired(now) && !ce.isDead() {
+			self.expire(ce)
+			rval++
+		}
+		return false
+	})
+	return
+}
+
+/*
+ Each will run i on each key and value that hasn't expired.
+
+ It returns true if the iteration was interrupted.
+ This is the case when one of the HashIterator calls returned true, indicating
+ the iteration should be stopped.
+*/
+func (self *Cache) Each(i HashIterator) bool {
+	now := time.Now().UnixNano()
+	return self.hash.Each(func(k Hashable, v Thing) bool {
+		ce := v.(*cacheEntry)
+		return !ce.isDead() && !ce.expired(now) && i(k, ce.val())
+	})
+}
+
+/*
+ ToMap returns a map[Hashable]Thing that is logically identical to the Cache.
+*/
+func (self *Cache) ToMap() map[Hashable]Thing {
+	rval := make(map[Hashable]Thing)
+	self.Each(func(k Hashable, v Thing) bool {
+		rval[k] = v
+		return false
+	})
+	return rval
+}
+func (self *Cache) String() string {
+	return fmt.Sprint(self.ToMap())
+}
@@ -0,0 +1,172 @@
+package gotomic
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fiddleCache(t *testing.T, cache *Cache, x string, do, done chan bool) {
+	<-do
+	for i := 0; i < 10000; i++ {
+		k := StringKey(fmt.Sprint(x, i%200))
+		cache.Put(k, i)
+		cache.Get(k)
+	}
+	done <- true
+}
+
+func TestCachePutGetDelete(t *testing.T) {
+	cache := NewCache(0, 0, nil)
+	if _, ok := cache.Get(StringKey("a")); ok {
+		t.Error(cache, "should not contain 'a'")
+	}
+	cache.Put(StringKey("a"), "1")
+	cache.Put(StringKey("b"), "2")
+	cache.Put(StringKey("a"), "3")
+	if v, ok := cache.Get(StringKey("a")); !ok || v != "3" {
+		t.Error(cache, "should contain 'a' => '3', got", v, ok)
+	}
+	if m := cache.ToMap(); !reflect.DeepEqual(m, map[Hashable]Thing{StringKey("a"): "3", StringKey("b"): "2"}) {
+		t.Error(cache, "has wrong contents", m)
+	}
+	if v, ok := cache.Delete(StringKey("b")); !ok || v != "2" {
+		t.Error(cache, "should delete 'b' => '2', got", v, ok)
+	}
+	if _, ok := cache.Get(StringKey("b")); ok {
+		t.Error(cache, "should not contain 'b'")
+	}
+	if stats := cache.Stats(); stats != (CacheStats{Hits: 1, Misses: 2}) {
+		t.Error(cache, "has wrong stats", stats)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	var expired []Thing
+	cache := NewCache(0, time.Hour, func(k Hashable, v Thing) {
+		expired = append(expired, v)
+	})
+	cache.PutTTL(StringKey("a"), "1", time.Millisecond)
+	cache.PutTTL(StringKey("b"), "2", time.Millisecond)
+	cache.Put(StringKey("c"), "3")
+	time.Sleep(time.Millisecond * 5)
+	if _, ok := cache.Get(StringKey("a")); ok {
+		t.Error(cache, "should have expired 'a'")
+	}
+	if n := cache.Purge(); n != 1 {
+		t.Error(cache, "should purge 1 entry, but purged", n)
+	}
+	if v, ok := cache.Get(StringKey("c")); !ok || v != "3" {
+		t.Error(cache, "should contain 'c' => '3', got", v, ok)
+	}
+	if cache.Size() != 1 {
+		t.Error(cache, "should have size 1, but had", cache.Size())
+	}
+	if !reflect.DeepEqual(expired, []Thing{"1", "2"}) {
+		t.Error(cache, "should have expired '1' and '2', but expired", expired)
+	}
+	if stats := cache.Stats(); stats.Expirations != 2 {
+		t.Error(cache, "should have 2 expirations, but had", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	var evicted []Thing
+	cache := NewCache(3, 0, func(k Hashable, v Thing) {
+		evicted = append(evicted, k)
+	})
+	cache.Put(IntKey(1), 1)
+	cache.Put(IntKey(2), 2)
+	cache.Put(IntKey(3), 3)
+	if len(evicted) != 0 {
+		t.Error(cache, "should not evict anything yet, but evicted", evicted)
+	}
+	cache.Get(IntKey(1))
+	cache.Get(IntKey(3))
+	cache.Put(IntKey(4), 4)
+	if !reflect.DeepEqual(evicted, []Thing{IntKey(2)}) {
+		t.Error(cache, "should evict 2, but evicted", evicted)
+	}
+	if m := cache.ToMap(); !reflect.DeepEqual(m, map[Hashable]Thing{IntKey(1): 1, IntKey(3): 3, IntKey(4): 4}) {
+		t.Error(cache, "has wrong contents", m)
+	}
+}
+
+func TestCacheAdmitDead(t *testing.T) {
+	cache := NewCache(2, 0, nil)
+	cache.Put(IntKey(1), 1)
+	cache.Put(IntKey(2), 2)
+	/*
+	 Like an entry deleted between being put in the Hash and being admitted, while the other slots are full.
+	*/
+	ce := &cacheEntry{key: IntKey(3), dead: 1}
+	done := make(chan bool)
+	go func() {
+		cache.admit(ce)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal(cache, "should not try to admit dead entries forever")
+	}
+	if m := cache.ToMap(); !reflect.DeepEqual(m, map[Hashable]Thing{IntKey(1): 1, IntKey(2): 2}) {
+		t.Error(cache, "has wrong contents", m)
+	}
+}
+
+func TestCacheClear(t *testing.T) {
+	cache := NewCache(3, 0, nil)
+	cache.Put(IntKey(1), 1)
+	cache.Put(IntKey(2), 2)
+	/*
+	 Like a Put landing in the cleared Hash before Clear gets to the slots.
+	*/
+	cache.hash.Clear()
+	cache.Put(IntKey(3), 3)
+	cache.clearSlots()
+	cache.Put(IntKey(4), 4)
+	cache.Put(IntKey(5), 5)
+	if m := cache.ToMap(); !reflect.DeepEqual(m, map[Hashable]Thing{IntKey(3): 3, IntKey(4): 4, IntKey(5): 5}) {
+		t.Error(cache, "has wrong contents", m)
+	}
+	cache.Clear()
+	if cache.Size() != 0 {
+		t.Error(cache, "should be empty")
+	}
+	cache.Put(IntKey(6), 6)
+	cache.Put(IntKey(7), 7)
+	cache.Put(IntKey(8), 8)
+	if m := cache.ToMap(); !reflect.DeepEqual(m, map[Hashable]Thing{IntKey(6): 6, IntKey(7): 7, IntKey(8): 8}) {
+		t.Error(cache, "has wrong contents", m)
+	}
+}
+
+func TestCacheConcurrency(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	var evictions int64
+	cache := NewCache(100, 0, func(k Hashable, v Thing) {
+		atomic.AddInt64(&evictions, 1)
+	})
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleCache(t, cache, fmt.Sprint("fiddler-", i, "-"), do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	if cache.Size() != 100 {
+		t.Error(cache, "should have size 100, but had", cache.Size())
+	}
+	if e := cache.Stats().Evictions; e != atomic.LoadInt64(&evictions) {
+		t.Error(cache, "should have", evictions, "evictions, but had", e)
+	}
+	if err := cache.hash.Verify(); err != nil {
+		t.Error(cache, "should be valid, got", err)
+	}
+}
@@ -0,0 +1,337 @@
+package gotomic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+/*
+ The binary format starts with codec_magic, a version byte and a byte telling what kind of structure it contains,
+ followed by the number of elements as an uvarint, followed by the elements as encoded by the Codec used.
+
+ For a Hash the elements are keys and values, alternating.
+ For a List the elements are the values, from the top of the List down.
+ For a Treap the elements are keys and values, alternating, in order.
+*/
+const codec_magic = "gotomic"
+const codec_version = 1
+
+const (
+	codec_kind_hash = iota
+	codec_kind_list
+	codec_kind_treap
+)
+
+func init() {
+	gob.Register(IntKey(0))
+	gob.Register(StringKey(""))
+}
+
+/*
+ Encoder encodes the elements of a structure.
+*/
+type Encoder interface {
+	Encode(t Thing) error
+}
+
+/*
+ Decoder decodes the elements of a structure.
+*/
+type Decoder interface {
+	Decode() (Thing, error)
+}
+
+/*
+ Codec creates Encoders and Decoders for the keys and values of structures being written or read.
+*/
+type Codec interface {
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+/*
+ GobCodec encodes keys and values using encoding/gob, and is what MarshalBinary and UnmarshalBinary use.
+
+ Since keys and values are interfaces, their concrete types have to be registered using gob.Register.
+ IntKey and StringKey are already registered.
+*/
+type GobCodec struct{}
+
+type gobEncoder struct {
+	*gob.Encoder
+}
+
+func (self gobEncoder) Encode(t Thing) error {
+	return self.Encoder.Encode(&t)
+}
+
+type gobDecoder struct {
+	*gob.Decoder
+}
+
+func (self gobDecoder) Decode() (rval Thing, err error) {
+	err = self.Decoder.Decode(&rval)
+	return
+}
+
+func (self GobCodec) NewEncoder(w io.Writer) Encoder {
+	return gobEncoder{gob.NewEncoder(w)}
+}
+func (self GobCodec) NewDecoder(r io.Reader) Decoder {
+	return gobDecoder{gob.NewDecoder(r)}
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+/*
+ newByteReader returns r if it already is a byteReader, so that we don't read past our data when possible.
+*/
+func newByteReader(r io.Reader) byteReader {
+	if br, ok := r.(byteReader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+func writeCodecHeader(w io.Writer, kind byte, size int) (err error) {
+	buf := make([]byte, len(codec_magic)+2+binary.MaxVarintLen64)
+	copy(buf, codec_magic)
+	buf[len(codec_magic)] = codec_version
+	buf[len(codec_magic)+1] = kind
+	n := binary.PutUvarint(buf[len(codec_magic)+2:], uint64(size))
+	_, err = w.Write(buf[:len(codec_magic)+2+n])
+	return
+}
+
+/*
+ readCodecHeader reads and checks the header, and returns the number of elements.
+
+ The number of elements is only as trustworthy as the data, so don't allocate anything based on it.
+*/
+func readCodecHeader(r byteReader, kind byte) (size int, err error) {
+	buf := make([]byte, len(codec_magic)+2)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+	if string(buf[:len(codec_magic)]) != codec_magic {
+		err = fmt.Errorf("%q is not a gotomic header", buf)
+		return
+	}
+	if buf[len(codec_magic)] != codec_version {
+		err = fmt.Errorf("Unsupported gotomic format version %v", buf[len(codec_magic)])
+		return
+	}
+	if buf[len(codec_magic)+1] != kind {
+		err = fmt.Errorf("Expected gotomic structure kind %v, but got %v", kind, buf[len(codec_magic)+1])
+		return
+	}
+	s, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	if size = int(s); size < 0 || uint64(size) != s {
+		err = fmt.Errorf("Unreasonable number of elements %v", s)
+	}
+	return
+}
+
+/*
+ EncodeTo writes a snapshot of the Hash to w, using c to encode the keys and values.
+*/
+func (self *Hash) EncodeTo(w io.Writer, c Codec) (err error) {
+	m := self.ToMap()
+	if err = writeCodecHeader(w, codec_kind_hash, len(m)); err != nil {
+		return
+	}
+	enc := c.NewEncoder(w)
+	for k, v := range m {
+		if err = enc.Encode(k); err != nil {
+			return
+		}
+		if err = enc.Encode(v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/*
+ DecodeFrom reads what EncodeTo wrote from r, using c to decode the keys and values, and puts it in the Hash.
+*/
+func (self *Hash) DecodeFrom(r io.Reader, c Codec) (err error) {
+	if self.table == nil {
+		self.table = unsafe.Pointer(newHashTable())
+		self.loadFactor = default_load_factor
+	}
+	br := newByteReader(r)
+	size, err := readCodecHeader(br, codec_kind_hash)
+	if err != nil {
+		return
+	}
+	dec := c.NewDecoder(br)
+	for i := 0; i < size; i++ {
+		var k, v Thing
+		if k, err = dec.Decode(); err != nil {
+			return
+		}
+		if v, err = dec.Decode(); err != nil {
+			return
+		}
+		hashable, ok := k.(Hashable)
+		if !ok {
+			return fmt.Errorf("%#v is not Hashable", k)
+		}
+		self.Put(hashable, v)
+	}
+	return
+}
+
+/*
+ MarshalBinary returns a snapshot of the Hash encoded using GobCodec.
+*/
+func (self *Hash) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := self.EncodeTo(buf, GobCodec{})
+	return buf.Bytes(), err
+}
+
+/*
+ UnmarshalBinary puts the contents of a Hash encoded by MarshalBinary in the Hash.
+*/
+func (self *Hash) UnmarshalBinary(b []byte) error {
+	return self.DecodeFrom(bytes.NewReader(b), GobCodec{})
+}
+
+/*
+ EncodeTo writes a snapshot of the List to w, using c to encode the values.
+*/
+func (self *List) EncodeTo(w io.Writer, c Codec) (err error) {
+	s := self.ToSlice()
+	if err = writeCodecHeader(w, codec_kind_list, len(s)); err != nil {
+		return
+	}
+	enc := c.NewEncoder(w)
+	for _, v := range s {
+		if err = enc.Encode(v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/*
+ DecodeFrom reads what EncodeTo wrote from r, using c to decode the values, and pushes them on top of the List in their original order.
+*/
+func (self *List) DecodeFrom(r io.Reader, c Codec) (err error) {
+	if self.element == nil {
+		self.element = &element{nil, &list_head}
+	}
+	br := newByteReader(r)
+	size, err := readCodecHeader(br, codec_kind_list)
+	if err != nil {
+		return
+	}
+	dec := c.NewDecoder(br)
+	var values []Thing
+	for i := 0; i < size; i++ {
+		var v Thing
+		if v, err = dec.Decode(); err != nil {
+			return
+		}
+		values = append(values, v)
+	}
+	for i := len(values) - 1; i >= 0; i-- {
+		self.Push(values[i])
+	}
+	return
+}
+
+/*
+ MarshalBinary returns a snapshot of the List encoded using GobCodec.
+*/
+func (self *List) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := self.EncodeTo(buf, GobCodec{})
+	return buf.Bytes(), err
+}
+
+/*
+ UnmarshalBinary pushes the contents of a List encoded by MarshalBinary on top of the List.
+*/
+func (self *List) UnmarshalBinary(b []byte) error {
+	return self.DecodeFrom(bytes.NewReader(b), GobCodec{})
+}
+
+/*
+ EncodeTo writes a snapshot of the Treap to w, using c to encode the keys and values.
+*/
+func (self *Treap) EncodeTo(w io.Writer, c Codec) (err error) {
+	keys, values := self.ToSlice()
+	if err = writeCodecHeader(w, codec_kind_treap, len(keys)); err != nil {
+		return
+	}
+	enc := c.NewEncoder(w)
+	for index, k := range keys {
+		if err = enc.Encode(k); err != nil {
+			return
+		}
+		if err = enc.Encode(values[index]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/*
+ DecodeFrom reads what EncodeTo wrote from r, using c to decode the keys and values, and puts it in the Treap.
+*/
+func (self *Treap) DecodeFrom(r io.Reader, c Codec) (err error) {
+	if self.handle == nil {
+		self.handle = NewHandle(&treap{})
+	}
+	br := newByteReader(r)
+	size, err := readCodecHeader(br, codec_kind_treap)
+	if err != nil {
+		return
+	}
+	dec := c.NewDecoder(br)
+	for i := 0; i < size; i++ {
+		var k, v Thing
+		if k, err = dec.Decode(); err != nil {
+			return
+		}
+		if v, err = dec.Decode(); err != nil {
+			return
+		}
+		comparable, ok := k.(Comparable)
+		if !ok {
+			return fmt.Errorf("%#v is not Comparable", k)
+		}
+		self.Put(comparable, v)
+	}
+	return
+}
+
+/*
+ MarshalBinary returns a snapshot of the Treap encoded using GobCodec.
+*/
+func (self *Treap) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := self.EncodeTo(buf, GobCodec{})
+	return buf.Bytes(), err
+}
+
+/*
+ UnmarshalBinary puts the contents of a Treap encoded by MarshalBinary in the Treap.
+*/
+func (self *Treap) UnmarshalBinary(b []byte) error {
+	return self.DecodeFrom(bytes.NewReader(b), GobCodec{})
+}
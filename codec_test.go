@@ -0,0 +1,149 @@
+package gotomic
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHashMarshalBinary(t *testing.T) {
+	h := NewHash()
+	cmp := make(map[Hashable]Thing)
+	for i := 0; i < 100; i++ {
+		h.Put(StringKey(fmt.Sprint("key", i)), i)
+		cmp[StringKey(fmt.Sprint("key", i))] = i
+	}
+	h.Put(IntKey(5), "five")
+	cmp[IntKey(5)] = "five"
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h2 Hash
+	if err := h2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	assertMappy(t, &h2, cmp)
+}
+
+func TestListMarshalBinary(t *testing.T) {
+	l := NewList()
+	for _, v := range []c{5, 3, 1} {
+		l.Inject(v)
+	}
+	gob.Register(c(0))
+	b, err := l.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l2 List
+	if err := l2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	assertListy(t, &l2, []Thing{c(1), c(3), c(5)})
+}
+
+func TestListCorruptHeader(t *testing.T) {
+	/*
+	 -1 ends up as the largest possible uvarint.
+	*/
+	for _, size := range []int{1 << 40, -1} {
+		buf := new(bytes.Buffer)
+		if err := writeCodecHeader(buf, codec_kind_list, size); err != nil {
+			t.Fatal(err)
+		}
+		var l List
+		if err := l.UnmarshalBinary(buf.Bytes()); err == nil {
+			t.Error(&l, "should not unmarshal a header claiming", uint64(size), "elements")
+		}
+	}
+}
+
+func TestTreapMarshalBinary(t *testing.T) {
+	gob.Register(s(""))
+	treap := NewTreap()
+	for _, k := range []string{"c", "a", "b"} {
+		treap.Put(s(k), strings.ToUpper(k))
+	}
+	/*
+	 Go through gob, which will use MarshalBinary and UnmarshalBinary.
+	*/
+	type checkpoint struct {
+		Index *Treap
+	}
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(checkpoint{treap}); err != nil {
+		t.Fatal(err)
+	}
+	var restored checkpoint
+	if err := gob.NewDecoder(buf).Decode(&restored); err != nil {
+		t.Fatal(err)
+	}
+	keys, values := restored.Index.ToSlice()
+	if !reflect.DeepEqual(keys, []Comparable{s("a"), s("b"), s("c")}) || !reflect.DeepEqual(values, []Thing{"A", "B", "C"}) {
+		t.Error(restored.Index, "should contain a, b and c, but contained", keys, values)
+	}
+}
+
+/*
+ lineCodec encodes StringKeys and strings as lines of text.
+*/
+type lineCodec struct{}
+type lineEncoder struct {
+	w io.Writer
+}
+type lineDecoder struct {
+	r byteReader
+}
+
+func (self lineEncoder) Encode(t Thing) (err error) {
+	_, err = fmt.Fprintln(self.w, t)
+	return
+}
+func (self lineDecoder) Decode() (Thing, error) {
+	buf := new(bytes.Buffer)
+	for {
+		b, err := self.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '\n' {
+			return StringKey(buf.String()), nil
+		}
+		buf.WriteByte(b)
+	}
+}
+func (self lineCodec) NewEncoder(w io.Writer) Encoder {
+	return lineEncoder{w}
+}
+func (self lineCodec) NewDecoder(r io.Reader) Decoder {
+	return lineDecoder{newByteReader(r)}
+}
+
+func TestHashCodec(t *testing.T) {
+	h := NewHash()
+	h.Put(StringKey("a"), StringKey("b"))
+	buf := new(bytes.Buffer)
+	if err := h.EncodeTo(buf, lineCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "a\nb\n") {
+		t.Errorf("%q should end with the keys and values as lines", buf.String())
+	}
+	h2 := NewHash()
+	if err := h2.DecodeFrom(buf, lineCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	assertMappy(t, h2, map[Hashable]Thing{StringKey("a"): StringKey("b")})
+	if err := NewTreap().DecodeFrom(bytes.NewBufferString("gotomic"), lineCodec{}); err == nil {
+		t.Error("should not decode a truncated header")
+	}
+	b, _ := h.MarshalBinary()
+	if err := NewTreap().UnmarshalBinary(b); err == nil {
+		t.Error("should not decode a Hash into a Treap")
+	}
+}
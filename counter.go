@@ -0,0 +1,166 @@
+package gotomic
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+type CounterIterator func(k Hashable, v int64) bool
+
+/*
+ CounterPair is a key and its count, as returned by CounterHash#TopN.
+*/
+type CounterPair struct {
+	Key   Hashable
+	Count int64
+}
+
+type counterPairs []CounterPair
+
+func (self counterPairs) Len() int {
+	return len(self)
+}
+func (self counterPairs) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self counterPairs) Less(i, j int) bool {
+	return self[i].Count > self[j].Count
+}
+
+/*
+ CounterHash is a hash table of int64 counters.
+
+ It uses the same split-ordered list as Hash, but each entry holds a pointer to an int64 that is updated
+ in place using atomic.AddInt64, so incrementing an existing key doesn't allocate anything.
+
+ It is thread safe and non-blocking.
+*/
+type CounterHash Hash
+
+func NewCounterHash() *CounterHash {
+	return (*CounterHash)(NewHash())
+}
+func (self *CounterHash) hash() *Hash {
+	return (*Hash)(self)
+}
+
+/*
+ Size returns the number of keys in the CounterHash.
+*/
+func (self *CounterHash) Size() int {
+	return self.hash().Size()
+}
+
+/*
+ Verify the integrity of the CounterHash.
+*/
+func (self *CounterHash) Verify() error {
+	return self.hash().Verify()
+}
+
+/*
+ counter returns the counter for k, creating it if it is missing.
+*/
+func (self *CounterHash) counter(k Hashable) *int64 {
+	hashCode := k.HashCode()
+	for {
+		if e := self.hash().findEntry(hashCode, k); e != nil {
+			return e.val().(*int64)
+		}
+		self.hash().putIfMissing(newRealEntryWithHashCode(k, new(int64), hashCode))
+	}
+}
+
+/*
+ Inc adds delta to the counter for k and returns the new value.
+
+ Increments racing with a Delete of the same key may be lost.
+*/
+func (self *CounterHash) Inc(k Hashable, delta int64) int64 {
+	return atomic.AddInt64(self.counter(k), delta)
+}
+
+/*
+ Get returns the value of the counter for k, or 0 if k is missing.
+*/
+func (self *CounterHash) Get(k Hashable) int64 {
+	if v, ok := self.hash().Get(k); ok {
+		return atomic.LoadInt64(v.(*int64))
+	}
+	return 0
+}
+
+/*
+ Delete removes the counter for k and returns its last value and whether it was present.
+*/
+func (self *CounterHash) Delete(k Hashable) (int64, bool) {
+	if v, ok := self.hash().Delete(k); ok {
+		return atomic.LoadInt64(v.(*int64)), true
+	}
+	return 0, false
+}
+
+/*
+ Each will run i on each key and count.
+
+ It returns true if the iteration was interrupted.
+ This is the case when one of the CounterIterator calls returned true, indicating
+ the iteration should be stopped.
+*/
+func (self *CounterHash) Each(i CounterIterator) bool {
+	return self.hash().Each(func(k Hashable, v Thing) bool {
+		return i(k, atomic.LoadInt64(v.(*int64)))
+	})
+}
+
+/*
+ Reset sets all counters to 0, keeping the keys.
+*/
+func (self *CounterHash) Reset() {
+	self.hash().Each(func(k Hashable, v Thing) bool {
+		atomic.StoreInt64(v.(*int64), 0)
+		return false
+	})
+}
+
+/*
+ Clear removes all keys from the CounterHash, like Hash#Clear.
+*/
+func (self *CounterHash) Clear() {
+	self.hash().Clear()
+}
+
+/*
+ TopN returns the n keys with the highest counts, highest first, or nothing if n <= 0.
+*/
+func (self *CounterHash) TopN(n int) []CounterPair {
+	if n <= 0 {
+		return nil
+	}
+	var rval counterPairs
+	self.Each(func(k Hashable, v int64) bool {
+		rval = append(rval, CounterPair{k, v})
+		return false
+	})
+	sort.Stable(rval)
+	if len(rval) > n {
+		rval = rval[:n]
+	}
+	return []CounterPair(rval)
+}
+
+/*
+ ToMap returns a map[Hashable]int64 that is logically identical to the CounterHash.
+*/
+func (self *CounterHash) ToMap() map[Hashable]int64 {
+	rval := make(map[Hashable]int64)
+	self.Each(func(k Hashable, v int64) bool {
+		rval[k] = v
+		return false
+	})
+	return rval
+}
+func (self *CounterHash) String() string {
+	return fmt.Sprint(self.ToMap())
+}
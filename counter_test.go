@@ -0,0 +1,86 @@
+package gotomic
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func fiddleCounterHash(t *testing.T, h *CounterHash, do, done chan bool) {
+	<-do
+	for i := 0; i < 10000; i++ {
+		h.Inc(IntKey(i%10), 1)
+		h.Inc(StringKey("total"), 2)
+	}
+	done <- true
+}
+
+func TestCounterHashIncGet(t *testing.T) {
+	h := NewCounterHash()
+	if v := h.Get(StringKey("a")); v != 0 {
+		t.Error(h, "should not contain 'a', but got", v)
+	}
+	if v := h.Inc(StringKey("a"), 3); v != 3 {
+		t.Error(h, "should produce 3 for 'a', but got", v)
+	}
+	if v := h.Inc(StringKey("a"), -1); v != 2 {
+		t.Error(h, "should produce 2 for 'a', but got", v)
+	}
+	h.Inc(StringKey("b"), 5)
+	h.Inc(StringKey("c"), 1)
+	if m := h.ToMap(); !reflect.DeepEqual(m, map[Hashable]int64{StringKey("a"): 2, StringKey("b"): 5, StringKey("c"): 1}) {
+		t.Error(h, "has wrong contents", m)
+	}
+	if top := h.TopN(2); !reflect.DeepEqual(top, []CounterPair{{StringKey("b"), 5}, {StringKey("a"), 2}}) {
+		t.Error(h, "should have 'b' and 'a' on top, but got", top)
+	}
+	if top := h.TopN(5); len(top) != 3 {
+		t.Error(h, "should have 3 keys on top, but got", top)
+	}
+	for _, n := range []int{0, -1} {
+		if top := h.TopN(n); len(top) != 0 {
+			t.Error(h, "should have nothing in the top", n, "but got", top)
+		}
+	}
+	if v, ok := h.Delete(StringKey("b")); !ok || v != 5 {
+		t.Error(h, "should delete 5 from 'b', but got", v, ok)
+	}
+	h.Reset()
+	if m := h.ToMap(); !reflect.DeepEqual(m, map[Hashable]int64{StringKey("a"): 0, StringKey("c"): 0}) {
+		t.Error(h, "should be reset, but was", m)
+	}
+}
+
+func TestCounterHashConcurrency(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	h := NewCounterHash()
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleCounterHash(t, h, do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	if err := h.Verify(); err != nil {
+		t.Error(h, "should be valid, got", err)
+	}
+	if v := h.Get(StringKey("total")); v != int64(runtime.NumCPU()*20000) {
+		t.Error(h, "should have", runtime.NumCPU()*20000, "for 'total', but got", v)
+	}
+	for i := 0; i < 10; i++ {
+		if v := h.Get(IntKey(i)); v != int64(runtime.NumCPU()*1000) {
+			t.Error(h, "should have", runtime.NumCPU()*1000, "for", i, "but got", v)
+		}
+	}
+}
+
+func BenchmarkCounterHashInc(b *testing.B) {
+	b.ReportAllocs()
+	h := NewCounterHash()
+	k := IntKey(1)
+	for i := 0; i < b.N; i++ {
+		h.Inc(k, 1)
+	}
+}
@@ -0,0 +1,524 @@
+package gotomic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+ How often the log is fsynced with SyncPeriodically if DurableOptions.SyncInterval is 0.
+*/
+const default_durable_sync_interval = time.Second
+
+const durable_snapshot_file = "snapshot"
+const durable_log_file = "log"
+
+const (
+	durable_op_put = iota
+	durable_op_delete
+)
+
+/*
+ SyncPolicy decides when a DurableTreap will fsync its log.
+*/
+type SyncPolicy int
+
+const (
+	/*
+	 SyncAlways will fsync the log before each Put or Delete returns.
+	*/
+	SyncAlways SyncPolicy = iota
+	/*
+	 SyncPeriodically will fsync the log every DurableOptions.SyncInterval.
+	*/
+	SyncPeriodically
+	/*
+	 SyncNever will leave it to the operating system to write the log to disk.
+	*/
+	SyncNever
+)
+
+/*
+ DurableOptions configures a DurableTreap.
+*/
+type DurableOptions struct {
+	/*
+	 Codec encodes the keys and values in the log and snapshots. If nil GobCodec will be used.
+	*/
+	Codec Codec
+	Sync  SyncPolicy
+	/*
+	 SyncInterval is how often the log is fsynced when Sync is SyncPeriodically. If 0 default_durable_sync_interval is used.
+	*/
+	SyncInterval time.Duration
+	/*
+	 SnapshotEvery is how many log records will be written before a new snapshot is taken and the log truncated.
+	 If 0 snapshots are only taken when Snapshot is called.
+	*/
+	SnapshotEvery int
+}
+
+/*
+ durableLog is what a DurableTreap needs from its log file.
+*/
+type durableLog interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+/*
+ DurableTreap is a Treap that writes all changes to an append only log before making them, and can be restored
+ from the latest snapshot and the log after a crash or restart.
+
+ The log contains the Put and Delete calls made, in the order they were committed, each record framed with its
+ length and checksum so that a record torn by a crash can be detected and dropped when recovering.
+
+ The log contains the logical operations rather than the writeHandles of each committed Transaction, since the
+ handles point to nodes that only exist in this process. Replaying the operations in order produces the same
+ contents.
+
+ Reads are as non-blocking as for a Treap, but writes are serialized to keep the log in commit order.
+*/
+type DurableTreap struct {
+	treap   *Treap
+	dir     string
+	options DurableOptions
+	/*
+	 Protects log, written, failed and snapshotErr, and orders writes.
+	*/
+	lock sync.Mutex
+	log  durableLog
+	/*
+	 The number of records written since the last snapshot was attempted.
+	*/
+	written int
+	/*
+	 Set when the log could not be restored after a failed write, after which no more writes are allowed.
+	*/
+	failed      error
+	snapshotErr error
+	stop        chan bool
+	stopped     chan bool
+	stopOnce    sync.Once
+}
+
+/*
+ OpenDurableTreap returns a DurableTreap storing its snapshot and log in dir, containing the latest snapshot
+ and everything logged after it.
+*/
+func OpenDurableTreap(dir string, options DurableOptions) (rval *DurableTreap, err error) {
+	if options.Codec == nil {
+		options.Codec = GobCodec{}
+	}
+	if options.SyncInterval == 0 {
+		options.SyncInterval = default_durable_sync_interval
+	} else if options.SyncInterval < 0 {
+		return nil, fmt.Errorf("Negative SyncInterval %v", options.SyncInterval)
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	rval = &DurableTreap{treap: NewTreap(), dir: dir, options: options}
+	if err = rval.recover(); err != nil {
+		return nil, err
+	}
+	if options.Sync == SyncPeriodically {
+		rval.stop = make(chan bool)
+		rval.stopped = make(chan bool)
+		go rval.syncPeriodically()
+	}
+	return
+}
+
+/*
+ recover loads the snapshot, replays the log and truncates it after the last intact record.
+
+ Only the last record may be broken, since a crash can only tear the record being written. A broken record
+ followed by anything else means the log is corrupt, and is returned as an error rather than dropping the rest.
+*/
+func (self *DurableTreap) recover() (err error) {
+	snapshot, err := os.Open(filepath.Join(self.dir, durable_snapshot_file))
+	if err == nil {
+		err = self.treap.DecodeFrom(snapshot, self.options.Codec)
+		snapshot.Close()
+		if err != nil {
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		return
+	}
+	log, err := os.OpenFile(filepath.Join(self.dir, durable_log_file), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	self.log = log
+	r := bufio.NewReader(self.log)
+	var intact int64
+	for {
+		var payload []byte
+		var n int64
+		if payload, n, err = readDurableRecord(r); err != nil {
+			break
+		}
+		/*
+		 An intact record that can't be replayed is not a crash artifact, so we refuse to drop it.
+		*/
+		if err = self.replay(payload); err != nil {
+			self.log.Close()
+			return
+		}
+		intact += n
+		self.written++
+	}
+	if err != io.EOF {
+		if err != io.ErrUnexpectedEOF {
+			if _, peekErr := r.Peek(1); peekErr != io.EOF {
+				self.log.Close()
+				return fmt.Errorf("Corrupt record at offset %v in %v: %v", intact, self.dir, err)
+			}
+		}
+		/*
+		 A torn or corrupt record at the end means we crashed while writing it, so it was never applied.
+		*/
+		if err = self.log.Truncate(intact); err != nil {
+			return
+		}
+	}
+	_, err = self.log.Seek(intact, io.SeekStart)
+	return
+}
+
+/*
+ readDurableRecord reads a record framed by writeDurableRecord, and returns its payload and its total length.
+*/
+func readDurableRecord(r *bufio.Reader) (payload []byte, n int64, err error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	if size > 1<<30 {
+		err = fmt.Errorf("Unreasonable record size %v", size)
+		return
+	}
+	buf := make([]byte, 4+size)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	payload = buf[4:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(buf) {
+		err = fmt.Errorf("Checksum mismatch")
+		return
+	}
+	n = int64(uvarintLen(size) + len(buf))
+	return
+}
+
+func uvarintLen(x uint64) int {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(buf, x)
+}
+
+/*
+ writeDurableRecord writes payload framed by its length and checksum.
+*/
+func writeDurableRecord(w io.Writer, payload []byte) (err error) {
+	buf := make([]byte, binary.MaxVarintLen64+4+len(payload))
+	n := binary.PutUvarint(buf, uint64(len(payload)))
+	binary.BigEndian.PutUint32(buf[n:], crc32.ChecksumIEEE(payload))
+	copy(buf[n+4:], payload)
+	_, err = w.Write(buf[:n+4+len(payload)])
+	return
+}
+
+func (self *DurableTreap) replay(payload []byte) (err error) {
+	if len(payload) == 0 {
+		return fmt.Errorf("Empty record")
+	}
+	dec := self.options.Codec.NewDecoder(bytes.NewReader(payload[1:]))
+	k, err := dec.Decode()
+	if err != nil {
+		return
+	}
+	comparable, ok := k.(Comparable)
+	if !ok {
+		return fmt.Errorf("%#v is not Comparable", k)
+	}
+	switch payload[0] {
+	case durable_op_put:
+		var v Thing
+		if v, err = dec.Decode(); err != nil {
+			return
+		}
+		self.treap.Put(comparable, v)
+	case durable_op_delete:
+		self.treap.Delete(comparable)
+	default:
+		return fmt.Errorf("Unknown record type %v", payload[0])
+	}
+	return
+}
+
+/*
+ write logs op on k (and v) and then runs apply, all while holding the lock.
+*/
+func (self *DurableTreap) write(op byte, k Comparable, v Thing, apply func()) (err error) {
+	buf := bytes.NewBuffer([]byte{op})
+	enc := self.options.Codec.NewEncoder(buf)
+	if err = enc.Encode(k); err != nil {
+		return
+	}
+	if op == durable_op_put {
+		if err = enc.Encode(v); err != nil {
+			return
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if err = self.writable(); err != nil {
+		return
+	}
+	if err = self.append(buf.Bytes()); err != nil {
+		return
+	}
+	apply()
+	self.written++
+	/*
+	 The write has succeeded no matter how the snapshot goes, since the log is only truncated after a successful snapshot.
+	*/
+	if self.options.SnapshotEvery > 0 && self.written >= self.options.SnapshotEvery {
+		self.snapshotErr = self.snapshot()
+		self.written = 0
+	}
+	return
+}
+
+/*
+ writable returns an error if the DurableTreap is closed or failed.
+*/
+func (self *DurableTreap) writable() error {
+	if self.log == nil {
+		return fmt.Errorf("%v is closed", self)
+	}
+	if self.failed != nil {
+		return fmt.Errorf("%v has failed: %v", self, self.failed)
+	}
+	return nil
+}
+
+/*
+ fail makes the DurableTreap refuse all further writes, and returns err.
+*/
+func (self *DurableTreap) fail(err error) error {
+	self.failed = err
+	return err
+}
+
+/*
+ append writes payload as a record at the end of the log, and fsyncs it if the SyncPolicy is SyncAlways.
+
+ If that fails the log is truncated to where it was before, so that the record the caller is told failed is
+ neither replayed nor left torn in front of later records. If that fails too the DurableTreap fails.
+*/
+func (self *DurableTreap) append(payload []byte) (err error) {
+	offset, err := self.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return self.fail(err)
+	}
+	if err = writeDurableRecord(self.log, payload); err == nil && self.options.Sync == SyncAlways {
+		err = self.log.Sync()
+	}
+	if err != nil {
+		if truncErr := self.log.Truncate(offset); truncErr != nil {
+			return self.fail(fmt.Errorf("%v, and then unable to truncate the log: %v", err, truncErr))
+		}
+		if _, seekErr := self.log.Seek(offset, io.SeekStart); seekErr != nil {
+			return self.fail(fmt.Errorf("%v, and then unable to seek in the log: %v", err, seekErr))
+		}
+	}
+	return
+}
+
+/*
+ SnapshotError returns the error from the last snapshot taken because of DurableOptions.SnapshotEvery, or nil if it succeeded.
+
+ A failed snapshot doesn't lose anything, since the log is kept until a snapshot succeeds.
+*/
+func (self *DurableTreap) SnapshotError() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.snapshotErr
+}
+
+/*
+ Put will log k and v, and then put them in the DurableTreap and return the overwritten value and whether any value was overwritten.
+*/
+func (self *DurableTreap) Put(k Comparable, v Thing) (old Thing, ok bool, err error) {
+	err = self.write(durable_op_put, k, v, func() {
+		old, ok = self.treap.Put(k, v)
+	})
+	return
+}
+
+/*
+ Delete will log the deletion of k, and then remove k from the DurableTreap and return any value it removed.
+*/
+func (self *DurableTreap) Delete(k Comparable) (old Thing, ok bool, err error) {
+	err = self.write(durable_op_delete, k, nil, func() {
+		old, ok = self.treap.Delete(k)
+	})
+	return
+}
+
+/*
+ Snapshot writes the contents of the DurableTreap to a new snapshot and truncates the log.
+*/
+func (self *DurableTreap) Snapshot() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if err := self.writable(); err != nil {
+		return err
+	}
+	return self.snapshot()
+}
+func (self *DurableTreap) snapshot() (err error) {
+	tmpName := filepath.Join(self.dir, durable_snapshot_file+".tmp")
+	tmp, err := os.Create(tmpName)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(tmp)
+	if err = self.treap.EncodeTo(w, self.options.Codec); err == nil {
+		if err = w.Flush(); err == nil {
+			err = tmp.Sync()
+		}
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Rename(tmpName, filepath.Join(self.dir, durable_snapshot_file)); err != nil {
+		return
+	}
+	if err = syncDir(self.dir); err != nil {
+		return
+	}
+	/*
+	 Only when the snapshot is safely in place can the log be dropped. If we can't get the log back in order
+	 after that, we can't write anything more.
+	*/
+	if err = self.log.Truncate(0); err != nil {
+		return self.fail(err)
+	}
+	if _, err = self.log.Seek(0, io.SeekStart); err != nil {
+		return self.fail(err)
+	}
+	self.written = 0
+	if err = self.log.Sync(); err != nil {
+		return self.fail(err)
+	}
+	return
+}
+
+func syncDir(dir string) (err error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+/*
+ Sync will fsync the log.
+*/
+func (self *DurableTreap) Sync() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.log == nil {
+		return fmt.Errorf("%v is closed", self)
+	}
+	return self.log.Sync()
+}
+
+func (self *DurableTreap) syncPeriodically() {
+	ticker := time.NewTicker(self.options.SyncInterval)
+	defer ticker.Stop()
+	defer close(self.stopped)
+	for {
+		select {
+		case <-ticker.C:
+			self.Sync()
+		case <-self.stop:
+			return
+		}
+	}
+}
+
+/*
+ Close will fsync and close the log. The DurableTreap can still be read, but not written, after this.
+
+ Safe to call multiple times.
+*/
+func (self *DurableTreap) Close() (err error) {
+	self.stopOnce.Do(func() {
+		if self.stop != nil {
+			close(self.stop)
+			<-self.stopped
+		}
+	})
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.log == nil {
+		return
+	}
+	if err = self.log.Sync(); err == nil {
+		err = self.log.Close()
+	} else {
+		self.log.Close()
+	}
+	self.log = nil
+	return
+}
+
+func (self *DurableTreap) String() string {
+	return fmt.Sprintf("&DurableTreap{%v size:%v}", self.dir, self.Size())
+}
+func (self *DurableTreap) Size() int {
+	return self.treap.Size()
+}
+func (self *DurableTreap) Get(k Comparable) (v Thing, ok bool) {
+	return self.treap.Get(k)
+}
+func (self *DurableTreap) Min() (k Comparable, v Thing, ok bool) {
+	return self.treap.Min()
+}
+func (self *DurableTreap) Max() (k Comparable, v Thing, ok bool) {
+	return self.treap.Max()
+}
+func (self *DurableTreap) Next(k Comparable) (key Comparable, value Thing, ok bool) {
+	return self.treap.Next(k)
+}
+func (self *DurableTreap) Previous(k Comparable) (key Comparable, value Thing, ok bool) {
+	return self.treap.Previous(k)
+}
+func (self *DurableTreap) Each(iter TreapIterator) error {
+	return self.treap.Each(iter)
+}
+func (self *DurableTreap) ToSlice() (keys []Comparable, values []Thing) {
+	return self.treap.ToSlice()
+}
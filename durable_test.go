@@ -0,0 +1,228 @@
+package gotomic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openDurable(t *testing.T, dir string, options DurableOptions) *DurableTreap {
+	d, err := OpenDurableTreap(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func assertDurable(t *testing.T, d *DurableTreap, keys []Comparable, values []Thing) {
+	k, v := d.ToSlice()
+	if !reflect.DeepEqual(k, keys) || !reflect.DeepEqual(v, values) {
+		t.Errorf("%v should contain %v => %v, but contained %v => %v", d, keys, values, k, v)
+	}
+	if d.Size() != len(keys) {
+		t.Errorf("%v should have size %v", d, len(keys))
+	}
+}
+
+func TestDurableTreap(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{})
+	d.Put(StringKey("a"), "1")
+	d.Put(StringKey("b"), "2")
+	d.Put(StringKey("c"), "3")
+	if old, ok, err := d.Put(StringKey("b"), "4"); err != nil || !ok || old != "2" {
+		t.Error(d, "should have replaced 2 with 4, but got", old, ok, err)
+	}
+	if old, ok, err := d.Delete(StringKey("a")); err != nil || !ok || old != "1" {
+		t.Error(d, "should have deleted 1, but got", old, ok, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.Put(StringKey("d"), "5"); err == nil {
+		t.Error(d, "should not allow writes after Close")
+	}
+	d = openDurable(t, dir, DurableOptions{})
+	assertDurable(t, d, []Comparable{StringKey("b"), StringKey("c")}, []Thing{"4", "3"})
+	d.Close()
+}
+
+func TestDurableTreapSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{Sync: SyncNever, SnapshotEvery: 7})
+	for i := 0; i < 20; i++ {
+		d.Put(IntKey(i), i)
+	}
+	if err := d.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, durable_log_file)); err != nil || info.Size() != 0 {
+		t.Error(d, "should have truncated the log after a snapshot, but got", info, err)
+	}
+	for i := 0; i < 20; i += 2 {
+		d.Delete(IntKey(i))
+	}
+	d.Close()
+	if _, err := OpenDurableTreap(dir, DurableOptions{Sync: SyncPeriodically, SyncInterval: -1}); err == nil {
+		t.Error("should not open with a negative SyncInterval")
+	}
+	d = openDurable(t, dir, DurableOptions{Sync: SyncPeriodically})
+	var keys []Comparable
+	var values []Thing
+	for i := 1; i < 20; i += 2 {
+		keys = append(keys, IntKey(i))
+		values = append(values, i)
+	}
+	assertDurable(t, d, keys, values)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(d, "should be possible to close twice, but got", err)
+	}
+}
+
+func TestDurableTreapTornLog(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{})
+	d.Put(StringKey("a"), "1")
+	d.Put(StringKey("b"), "2")
+	d.Close()
+	name := filepath.Join(dir, durable_log_file)
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(name, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	d = openDurable(t, dir, DurableOptions{})
+	assertDurable(t, d, []Comparable{StringKey("a")}, []Thing{"1"})
+	d.Put(StringKey("c"), "3")
+	d.Close()
+	d = openDurable(t, dir, DurableOptions{})
+	assertDurable(t, d, []Comparable{StringKey("a"), StringKey("c")}, []Thing{"1", "3"})
+	d.Close()
+}
+
+/*
+ flipByte inverts the byte at offset in the file name.
+*/
+func flipByte(t *testing.T, name string, offset int64) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	if _, err = f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err = f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDurableTreapCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, durable_log_file)
+	d := openDurable(t, dir, DurableOptions{})
+	d.Put(StringKey("a"), "1")
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := info.Size()
+	d.Put(StringKey("b"), "2")
+	d.Put(StringKey("c"), "3")
+	d.Close()
+	if info, err = os.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, name, info.Size()-1)
+	d = openDurable(t, dir, DurableOptions{})
+	assertDurable(t, d, []Comparable{StringKey("a"), StringKey("b")}, []Thing{"1", "2"})
+	d.Close()
+	flipByte(t, name, first-1)
+	if d, err = OpenDurableTreap(dir, DurableOptions{}); err == nil {
+		t.Error(d, "should not open with a corrupt record followed by intact ones")
+	}
+}
+
+/*
+ faultyLog writes at most writeLimit bytes of each Write before failing, and fails Truncate if truncateFails is set.
+*/
+type faultyLog struct {
+	durableLog
+	writeLimit    int
+	truncateFails bool
+}
+
+func (self *faultyLog) Write(b []byte) (int, error) {
+	if len(b) > self.writeLimit {
+		n, _ := self.durableLog.Write(b[:self.writeLimit])
+		return n, fmt.Errorf("disk full")
+	}
+	return self.durableLog.Write(b)
+}
+func (self *faultyLog) Truncate(size int64) error {
+	if self.truncateFails {
+		return fmt.Errorf("truncate failed")
+	}
+	return self.durableLog.Truncate(size)
+}
+
+func TestDurableTreapWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{})
+	d.Put(StringKey("a"), "1")
+	log := d.log
+	d.log = &faultyLog{log, 3, false}
+	if _, _, err := d.Put(StringKey("b"), "2"); err == nil {
+		t.Error(d, "should fail to put b")
+	}
+	if _, ok := d.Get(StringKey("b")); ok {
+		t.Error(d, "should not contain b after failing to put it")
+	}
+	d.log = log
+	d.Put(StringKey("c"), "3")
+	d.log = &faultyLog{log, 3, true}
+	if _, _, err := d.Put(StringKey("d"), "4"); err == nil {
+		t.Error(d, "should fail to put d")
+	}
+	d.log = log
+	if _, _, err := d.Put(StringKey("e"), "5"); err == nil {
+		t.Error(d, "should refuse writes after failing to truncate the log")
+	}
+	d.Close()
+	d = openDurable(t, dir, DurableOptions{})
+	assertDurable(t, d, []Comparable{StringKey("a"), StringKey("c")}, []Thing{"1", "3"})
+	d.Close()
+}
+
+func TestDurableTreapSnapshotFailure(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{SnapshotEvery: 1})
+	tmp := filepath.Join(dir, durable_snapshot_file+".tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.Put(StringKey("a"), "1"); err != nil {
+		t.Error(d, "should not fail a put because the snapshot failed, but got", err)
+	}
+	if d.SnapshotError() == nil {
+		t.Error(d, "should report the failed snapshot")
+	}
+	os.Remove(tmp)
+	d.Put(StringKey("b"), "2")
+	if err := d.SnapshotError(); err != nil {
+		t.Error(d, "should have snapshotted, but got", err)
+	}
+	d.Close()
+	d = openDurable(t, dir, DurableOptions{})
+	assertDurable(t, d, []Comparable{StringKey("a"), StringKey("b")}, []Thing{"1", "2"})
+	d.Close()
+}
@@ -0,0 +1,205 @@
+package gotomic
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+ How many callbacks Retire lets pile up before it tries to run the ones that are safe to run.
+*/
+const epoch_collect_threshold = 64
+
+type epochRecord struct {
+	/*
+	 The epoch the owning Guard was pinned in, shifted left one bit, with the lowest bit set while pinned.
+	*/
+	state uint64
+	inUse int32
+	next  *epochRecord
+	/*
+	 The Guard handed out when the record is used, so that pinning doesn't allocate anything.
+	*/
+	guard Guard
+}
+
+type retiredCallback struct {
+	epoch    uint64
+	callback func()
+	next     *retiredCallback
+}
+
+/*
+ Epoch is an epoch based reclamation facility, as described in "Practical lock-freedom" by Keir Fraser <http://www.cl.cam.ac.uk/techreports/UCAM-CL-TR-579.pdf>.
+
+ TL;DR: Readers Pin a Guard before looking at a shared structure and Unpin it when they are done. Writers Retire
+ a callback for each thing they remove from the structure, tagged with the current global epoch. The global epoch
+ can only advance when all pinned Guards have seen the current epoch, so when it has advanced twice past the epoch
+ of a callback, no reader pinned when the thing was removed can still be looking at it, and the callback is run.
+
+ It doesn't know anything about the structures it protects, so it can be used with List, Hash or anything else,
+ as long as all readers Pin a Guard while they use it.
+
+ It is thread safe and non-blocking, but callbacks are only run from Retire and Collect.
+*/
+type Epoch struct {
+	global uint64
+	/*
+	 The global epoch the last time Collect looked at the retired callbacks.
+	*/
+	collected uint64
+	pending   int64
+	records   unsafe.Pointer
+	retired   unsafe.Pointer
+}
+
+/*
+ Guard is a pinned reader of an Epoch.
+*/
+type Guard struct {
+	epoch  *Epoch
+	record *epochRecord
+}
+
+func NewEpoch() *Epoch {
+	return &Epoch{}
+}
+func (self *Epoch) String() string {
+	return fmt.Sprintf("&Epoch{%p global:%v pending:%v}", self, atomic.LoadUint64(&self.global), self.Pending())
+}
+
+/*
+ Pending returns the number of retired callbacks that have not yet been run.
+*/
+func (self *Epoch) Pending() int {
+	return int(atomic.LoadInt64(&self.pending))
+}
+
+func (self *Epoch) getRecord() *epochRecord {
+	for record := (*epochRecord)(atomic.LoadPointer(&self.records)); record != nil; record = record.next {
+		if atomic.CompareAndSwapInt32(&record.inUse, 0, 1) {
+			return record
+		}
+	}
+	record := &epochRecord{0, 1, nil, Guard{self, nil}}
+	record.guard.record = record
+	for {
+		record.next = (*epochRecord)(atomic.LoadPointer(&self.records))
+		if atomic.CompareAndSwapPointer(&self.records, unsafe.Pointer(record.next), unsafe.Pointer(record)) {
+			return record
+		}
+	}
+}
+
+/*
+ Pin returns a Guard that will prevent callbacks retired from now on from running until it is unpinned.
+*/
+func (self *Epoch) Pin() *Guard {
+	record := self.getRecord()
+	for {
+		global := atomic.LoadUint64(&self.global)
+		atomic.StoreUint64(&record.state, global<<1|1)
+		/*
+		 If the global epoch advanced before we published our state, the advancer may not have seen us.
+		*/
+		if atomic.LoadUint64(&self.global) == global {
+			break
+		}
+	}
+	return &record.guard
+}
+
+/*
+ Unpin releases the Guard. It must not be used after this.
+
+ Unpinning a nil Guard does nothing, so that optionally pinned code can just defer Unpin.
+*/
+func (self *Guard) Unpin() {
+	if self == nil {
+		return
+	}
+	atomic.StoreUint64(&self.record.state, 0)
+	atomic.StoreInt32(&self.record.inUse, 0)
+}
+
+/*
+ tryAdvance will advance the global epoch if all pinned Guards have seen it.
+*/
+func (self *Epoch) tryAdvance() {
+	global := atomic.LoadUint64(&self.global)
+	for record := (*epochRecord)(atomic.LoadPointer(&self.records)); record != nil; record = record.next {
+		if state := atomic.LoadUint64(&record.state); state&1 == 1 && state>>1 != global {
+			return
+		}
+	}
+	atomic.CompareAndSwapUint64(&self.global, global, global+1)
+}
+
+/*
+ Retire will make sure f is run once no Guard that is pinned right now is pinned anymore.
+*/
+func (self *Epoch) Retire(f func()) {
+	self.retire(&retiredCallback{0, f, nil})
+}
+
+/*
+ retire is Retire for callbacks that are already allocated, which can be reused once their callback has run.
+*/
+func (self *Epoch) retire(retired *retiredCallback) {
+	retired.epoch = atomic.LoadUint64(&self.global)
+	for {
+		retired.next = (*retiredCallback)(atomic.LoadPointer(&self.retired))
+		if atomic.CompareAndSwapPointer(&self.retired, unsafe.Pointer(retired.next), unsafe.Pointer(retired)) {
+			break
+		}
+	}
+	if atomic.AddInt64(&self.pending, 1)%epoch_collect_threshold == 0 {
+		self.Collect()
+	}
+}
+
+/*
+ Collect tries to advance the global epoch, and runs all retired callbacks that are safe to run.
+
+ Returns the number of callbacks run.
+*/
+func (self *Epoch) Collect() (rval int) {
+	self.tryAdvance()
+	global := atomic.LoadUint64(&self.global)
+	/*
+	 If the global epoch hasn't advanced since someone last looked, no more callbacks can be run.
+	*/
+	collected := atomic.LoadUint64(&self.collected)
+	if global == collected || !atomic.CompareAndSwapUint64(&self.collected, collected, global) {
+		return
+	}
+	/*
+	 By taking the whole retired stack we own all callbacks in it, and can push back the ones we don't run.
+	*/
+	var keep, keepTail *retiredCallback
+	for retired := (*retiredCallback)(atomic.SwapPointer(&self.retired, nil)); retired != nil; {
+		next := retired.next
+		if global >= retired.epoch+2 {
+			retired.callback()
+			atomic.AddInt64(&self.pending, -1)
+			rval++
+		} else {
+			if keepTail == nil {
+				keepTail = retired
+			}
+			retired.next = keep
+			keep = retired
+		}
+		retired = next
+	}
+	if keep != nil {
+		for {
+			keepTail.next = (*retiredCallback)(atomic.LoadPointer(&self.retired))
+			if atomic.CompareAndSwapPointer(&self.retired, unsafe.Pointer(keepTail.next), unsafe.Pointer(keep)) {
+				break
+			}
+		}
+	}
+	return
+}
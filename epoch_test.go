@@ -0,0 +1,81 @@
+package gotomic
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEpoch(t *testing.T) {
+	e := NewEpoch()
+	run := 0
+	g := e.Pin()
+	e.Retire(func() { run++ })
+	if e.Pending() != 1 {
+		t.Error(e, "should have 1 pending callback, but had", e.Pending())
+	}
+	for i := 0; i < 5; i++ {
+		e.Collect()
+	}
+	if run != 0 {
+		t.Error(e, "should not run the callback while a Guard is pinned")
+	}
+	g.Unpin()
+	g = e.Pin()
+	for i := 0; i < 5; i++ {
+		e.Collect()
+	}
+	if run != 1 {
+		t.Error(e, "should have run the callback once, but ran it", run, "times")
+	}
+	if e.Pending() != 0 {
+		t.Error(e, "should have no pending callbacks, but had", e.Pending())
+	}
+	g.Unpin()
+}
+
+type epochBuffer struct {
+	freed int32
+}
+
+func fiddleEpoch(t *testing.T, e *Epoch, h *Hash, do, done chan bool) {
+	<-do
+	for i := 0; i < 10000; i++ {
+		g := e.Pin()
+		if v, ok := h.Get(StringKey("buf")); ok {
+			b := v.(*epochBuffer)
+			runtime.Gosched()
+			if atomic.LoadInt32(&b.freed) == 1 {
+				t.Error(e, "freed", b, "while it was in use")
+			}
+		}
+		g.Unpin()
+	}
+	done <- true
+}
+
+func TestEpochConc(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	e := NewEpoch()
+	h := NewHash()
+	h.Put(StringKey("buf"), &epochBuffer{})
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleEpoch(t, e, h, do, done)
+	}
+	close(do)
+	for i := 0; i < 10000; i++ {
+		if old, ok := h.Put(StringKey("buf"), &epochBuffer{}); ok {
+			b := old.(*epochBuffer)
+			e.Retire(func() { atomic.StoreInt32(&b.freed, 1) })
+		}
+		runtime.Gosched()
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	for e.Pending() > 0 {
+		e.Collect()
+	}
+}
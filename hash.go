@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"hash/crc32"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"unsafe"
 )
@@ -15,28 +17,6 @@ type HashIterator func(k Hashable, v Thing) bool
 
 type hashHit hit
 
-func (self *hashHit) search(cmp *entry) (rval *hashHit) {
-	rval = &hashHit{self.left, self.element, self.right}
-	for {
-		if rval.element == nil {
-			break
-		}
-		rval.right = rval.element.next()
-		e := rval.element.value.(*entry)
-		if e.hashKey != cmp.hashKey {
-			rval.right = rval.element
-			rval.element = nil
-			break
-		}
-		if cmp.key.Equals(e.key) {
-			break
-		}
-		rval.left = rval.element
-		rval.element = rval.left.next()
-		rval.right = nil
-	}
-	return
-}
 func (self *hashHit) String() string {
 	return fmt.Sprint("&hashHit{", self.left.val(), self.element.val(), self.right.val(), "}")
 }
@@ -54,7 +34,7 @@ type Hashable interface {
 }
 
 /*
- Convenience type to simplify using ints as keys in a Hash
+ Convenience type to simplify using ints as keys in a Hash or Treap
 */
 type IntKey int
 
@@ -67,9 +47,20 @@ func (self IntKey) Equals(t Thing) bool {
 	}
 	return false
 }
+func (self IntKey) Compare(t Thing) int {
+	if ik, ok := t.(IntKey); ok {
+		if self > ik {
+			return 1
+		} else if self < ik {
+			return -1
+		}
+		return 0
+	}
+	panic(fmt.Errorf("%#v can only compare to other IntKeys, not %#v of type %T", self, t, t))
+}
 
 /*
- Convenience type to simplify using strings as keys in a Hash
+ Convenience type to simplify using strings as keys in a Hash or Treap
 */
 type StringKey string
 
@@ -82,6 +73,12 @@ func (self StringKey) Equals(t Thing) bool {
 	}
 	return false
 }
+func (self StringKey) Compare(t Thing) int {
+	if sk, ok := t.(StringKey); ok {
+		return strings.Compare(string(self), string(sk))
+	}
+	panic(fmt.Errorf("%#v can only compare to other StringKeys, not %#v of type %T", self, t, t))
+}
 
 type entry struct {
 	hashCode uint32
@@ -96,6 +93,27 @@ func newRealEntryWithHashCode(k Hashable, v Thing, hc uint32) *entry {
 func newRealEntry(k Hashable, v Thing) *entry {
 	return newRealEntryWithHashCode(k, v, k.HashCode())
 }
+func newKeyEntryWithHashCode(k Hashable, hc uint32) *entry {
+	return &entry{hc, reverse(hc) | 1, k, nil}
+}
+
+/*
+ hashNode keeps the element and entry for a key in a Hash together, so that they can be allocated and recycled together.
+*/
+type hashNode struct {
+	element element
+	entry   entry
+	retired retiredCallback
+}
+
+/*
+ hashPool recycles the hashNodes of deleted keys once its Epoch says no reader can see them anymore.
+*/
+type hashPool struct {
+	epoch *Epoch
+	nodes sync.Pool
+}
+
 func newMockEntry(hashCode uint32) *entry {
 	return &entry{hashCode, reverse(hashCode) &^ 1, nil, nil}
 }
@@ -103,10 +121,11 @@ func (self *entry) real() bool {
 	return self.hashKey&1 == 1
 }
 func (self *entry) val() Thing {
-	if self.value == nil {
+	value := atomic.LoadPointer(&self.value)
+	if value == nil {
 		return nil
 	}
-	return *(*Thing)(atomic.LoadPointer(&self.value))
+	return *(*Thing)(value)
 }
 func (self *entry) String() string {
 	return fmt.Sprintf("&entry{%0.32b/%0.32b, %v=>%v}", self.hashCode, self.hashKey, self.key, self.val())
@@ -134,22 +153,112 @@ func (self *entry) Compare(t Thing) int {
 
  To enable growing the table a two dimensional slice of unsafe.Pointers is used, where each consecutive slice is twice the size of the one before.
  This makes it simple to allocate exponentially more memory for the table with only a single extra indirection.
+
+ The table, the list and the size are kept together in a hashTable, so that Clear can replace all of them at once.
 */
 type Hash struct {
-	exponent   uint32
-	buckets    []unsafe.Pointer
-	size       int64
+	/*
+	 Will point to a hashTable.
+	*/
+	table      unsafe.Pointer
 	loadFactor float64
+	/*
+	 Will be nil unless the Hash was created with NewPooledHash.
+	*/
+	pool *hashPool
+	/*
+	 Will point to a hashWatch once Watch has been called.
+	*/
+	watch unsafe.Pointer
 }
 
-func NewHash() *Hash {
-	rval := &Hash{0, make([]unsafe.Pointer, max_exponent), 0, default_load_factor}
+type hashTable struct {
+	size     int64
+	exponent uint32
+	buckets  []unsafe.Pointer
+}
+
+func newHashTable() *hashTable {
+	rval := &hashTable{0, 0, make([]unsafe.Pointer, max_exponent)}
 	b := make([]unsafe.Pointer, 1)
 	rval.buckets[0] = unsafe.Pointer(&b)
 	return rval
 }
+
+func NewHash() *Hash {
+	return &Hash{unsafe.Pointer(newHashTable()), default_load_factor, nil, nil}
+}
+
+/*
+ NewPooledHash returns a Hash that recycles the memory of deleted keys for new keys.
+
+ Every operation on it will Pin a Guard from epoch while running, and the memory of deleted keys is retired
+ in epoch and reused once no operation running when they were deleted is still running.
+
+ Note that retired memory is only reused after Retire or Collect on epoch has run its callback.
+*/
+func NewPooledHash(epoch *Epoch) *Hash {
+	return &Hash{unsafe.Pointer(newHashTable()), default_load_factor, &hashPool{epoch: epoch}, nil}
+}
+
+/*
+ pin returns a pinned Guard if the Hash is pooled, and nil otherwise.
+*/
+func (self *Hash) pin() *Guard {
+	if self.pool == nil {
+		return nil
+	}
+	return self.pool.epoch.Pin()
+}
+
+/*
+ newNode returns a hashNode containing an entry for hashCode, k and value, recycled if possible.
+*/
+func (self *Hash) newNode(hashCode uint32, k Hashable, value unsafe.Pointer) (rval *hashNode) {
+	if self.pool != nil {
+		rval, _ = self.pool.nodes.Get().(*hashNode)
+	}
+	if rval == nil {
+		rval = &hashNode{}
+		if pool := self.pool; pool != nil {
+			node := rval
+			node.retired.callback = func() {
+				pool.nodes.Put(node)
+			}
+		}
+	}
+	rval.entry = entry{hashCode, reverse(hashCode) | 1, k, value}
+	return
+}
+
+/*
+ retire makes sure the memory of the deleted element n is reused once nobody can see it anymore, if the Hash is pooled.
+
+ n must have been unlinked from the list already, since new readers could otherwise find it.
+*/
+func (self *Hash) retire(n *element) {
+	if self.pool != nil {
+		self.pool.epoch.retire(&(*hashNode)(unsafe.Pointer(n)).retired)
+	}
+}
+func (self *Hash) getTable() *hashTable {
+	return (*hashTable)(atomic.LoadPointer(&self.table))
+}
 func (self *Hash) Size() int {
-	return int(atomic.LoadInt64(&self.size))
+	return int(atomic.LoadInt64(&self.getTable().size))
+}
+
+/*
+ Clear removes all entries from the Hash by replacing its table and list with empty ones.
+
+ Concurrent readers will see either the old or the empty contents, and operations running concurrently
+ with Clear may end up being applied to the old contents.
+*/
+func (self *Hash) Clear() {
+	w := self.lockWatchAll()
+	defer w.unlockAll()
+	atomic.StorePointer(&self.table, unsafe.Pointer(newHashTable()))
+	w.notify(HashEvent{HashClear, nil, nil, false, nil})
 }
 
 /*
@@ -160,7 +269,8 @@ func (self *Hash) Size() int {
  the iteration should be stopped.
 */
 func (self *Hash) Each(i HashIterator) bool {
-	return self.getBucketByHashCode(0).each(func(t Thing) bool {
+	defer self.pin().Unpin()
+	return self.getTable().getBucketByHashCode(0).each(func(t Thing) bool {
 		e := t.(*entry)
 		return e.real() && i(e.key, e.val())
 	})
@@ -170,18 +280,20 @@ func (self *Hash) Each(i HashIterator) bool {
  Verify the integrity of the Hash. Used mostly in my own tests but go ahead and call it if you fear corruption.
 */
 func (self *Hash) Verify() error {
-	bucket := self.getBucketByHashCode(0)
+	defer self.pin().Unpin()
+	table := self.getTable()
+	bucket := table.getBucketByHashCode(0)
 	if e := bucket.verify(); e != nil {
 		return e
 	}
 	for bucket != nil {
 		e := bucket.value.(*entry)
 		if e.real() {
-			if ok, index, super, sub := self.isBucket(bucket); ok {
+			if ok, index, super, sub := table.isBucket(bucket); ok {
 				return fmt.Errorf("%v has %v that should not be a bucket but is bucket %v (%v, %v)", self, e, index, super, sub)
 			}
 		} else {
-			if ok, _, _, _ := self.isBucket(bucket); !ok {
+			if ok, _, _, _ := table.isBucket(bucket); !ok {
 				return fmt.Errorf("%v has %v that should be a bucket but isn't", self, e)
 			}
 		}
@@ -204,9 +316,9 @@ func (self *Hash) ToMap() map[Hashable]Thing {
 	return rval
 }
 
-func (self *Hash) isBucket(n *element) (isBucket bool, index, superIndex, subIndex uint32) {
+func (self *hashTable) isBucket(n *element) (isBucket bool, index, superIndex, subIndex uint32) {
 	e := n.value.(*entry)
-	index = e.hashCode & ((1 << self.exponent) - 1)
+	index = e.hashCode & ((1 << atomic.LoadUint32(&self.exponent)) - 1)
 	superIndex, subIndex = self.getBucketIndices(index)
 	subBucket := *(*[]unsafe.Pointer)(atomic.LoadPointer(&self.buckets[superIndex]))
 	if subBucket[subIndex] == unsafe.Pointer(n) {
@@ -220,11 +332,13 @@ func (self *Hash) isBucket(n *element) (isBucket bool, index, superIndex, subInd
  those of you interested in debugging it or seeing an example of how split-ordered lists work.
 */
 func (self *Hash) Describe() string {
-	buffer := bytes.NewBufferString(fmt.Sprintf("&Hash{%p size:%v exp:%v maxload:%v}\n", self, self.size, self.exponent, self.loadFactor))
-	element := self.getBucketByIndex(0)
+	defer self.pin().Unpin()
+	table := self.getTable()
+	buffer := bytes.NewBufferString(fmt.Sprintf("&Hash{%p size:%v exp:%v maxload:%v}\n", self, table.size, table.exponent, self.loadFactor))
+	element := table.getBucketByIndex(0)
 	for element != nil {
 		e := element.value.(*entry)
-		if ok, index, super, sub := self.isBucket(element); ok {
+		if ok, index, super, sub := table.isBucket(element); ok {
 			fmt.Fprintf(buffer, "%3v:%3v,%3v: %v *\n", index, super, sub, e)
 		} else {
 			fmt.Fprintf(buffer, "             %v\n", e)
@@ -243,16 +357,48 @@ func (self *Hash) String() string {
  Use this when you already have the hash code and don't want to force gotomic to calculate it again.
 */
 func (self *Hash) GetHC(hashCode uint32, k Hashable) (rval Thing, ok bool) {
-	testEntry := newRealEntryWithHashCode(k, nil, hashCode)
-	bucket := self.getBucketByHashCode(testEntry.hashCode)
-	hit := (*hashHit)(bucket.search(testEntry))
-	if hit2 := hit.search(testEntry); hit2.element != nil {
-		rval = hit2.element.value.(*entry).val()
-		ok = true
+	defer self.pin().Unpin()
+	if e := self.findEntry(hashCode, k); e != nil {
+		return e.val(), true
 	}
 	return
 }
 
+/*
+ find returns the element with hashCode and a key that equals k (or nil), the last element before it
+ and the first element after it (or the elements between which it would be, if it isn't there).
+
+ Unlike element.search it doesn't allocate anything while searching.
+*/
+func (self *hashTable) find(hashCode uint32, k Hashable) (left, match, right *element) {
+	hashKey := reverse(hashCode) | 1
+	left = self.getBucketByHashCode(hashCode)
+	for {
+		right = left.next()
+		if right == nil {
+			return
+		}
+		e := right.value.(*entry)
+		if e.hashKey > hashKey {
+			return
+		}
+		if e.hashKey == hashKey && k.Equals(e.key) {
+			return left, right, right.next()
+		}
+		left = right
+	}
+}
+
+/*
+ findEntry returns the entry with hashCode and a key that equals k, or nil.
+*/
+func (self *Hash) findEntry(hashCode uint32, k Hashable) *entry {
+	if _, match, _ := self.getTable().find(hashCode, k); match != nil {
+		return match.value.(*entry)
+	}
+	return nil
+}
+
 /*
  Get returns the value at k and whether it was present in the Hash.
 */
@@ -266,23 +412,58 @@ func (self *Hash) Get(k Hashable) (Thing, bool) {
  Use this when you already have the hash code and don't want to force gotomic to calculate it again.
 */
 func (self *Hash) DeleteHC(hashCode uint32, k Hashable) (rval Thing, ok bool) {
-	testEntry := newRealEntryWithHashCode(k, nil, hashCode)
+	defer self.pin().Unpin()
+	w := self.lockWatch(hashCode)
+	defer w.unlock(hashCode)
+	table := self.getTable()
 	for {
-		bucket := self.getBucketByHashCode(testEntry.hashCode)
-		hit := (*hashHit)(bucket.search(testEntry))
-		if hit2 := hit.search(testEntry); hit2.element != nil {
-			if hit2.element.doRemove() {
-				hit2.left.next()
-				rval = hit2.element.value.(*entry).val()
-				ok = true
-				self.addSize(-1)
-				break
-			}
-		} else {
-			break
+		left, match, _ := table.find(hashCode, k)
+		if match == nil {
+			return
+		}
+		if match.doRemove() {
+			left.next()
+			rval = match.value.(*entry).val()
+			self.addSize(table, -1)
+			self.unlink(table, hashCode, k, match)
+			w.notify(HashEvent{HashDelete, k, rval, true, nil})
+			return rval, true
+		}
+	}
+}
+
+/*
+ unlink makes sure the deleted element n is unlinked from the list, by searching past where it was (which will unlink all
+ deleted elements on the way), and then retires it.
+*/
+func (self *Hash) unlink(table *hashTable, hashCode uint32, k Hashable, n *element) {
+	if self.pool != nil {
+		table.find(hashCode, k)
+		self.retire(n)
+	}
+}
+
+/*
+ deleteIfIdentical removes k from the Hash if its value is exactly v (compared with ==), and returns whether it removed anything.
+
+ It is only safe to use if the value under k is never overwritten in place, since the value could otherwise change
+ between comparing it and removing the entry.
+*/
+func (self *Hash) deleteIfIdentical(hashCode uint32, k Hashable, v Thing) bool {
+	defer self.pin().Unpin()
+	table := self.getTable()
+	for {
+		left, match, _ := table.find(hashCode, k)
+		if match == nil || match.value.(*entry).val() != v {
+			return false
+		}
+		if match.doRemove() {
+			left.next()
+			self.addSize(table, -1)
+			self.unlink(table, hashCode, k, match)
+			return true
 		}
 	}
-	return
 }
 
 /*
@@ -293,50 +474,85 @@ func (self *Hash) Delete(k Hashable) (Thing, bool) {
 }
 
 /*
- PutIfMissing will insert v under k if k contains expected in the Hash, and return whether it inserted anything.
+ PutIfPresent will insert v under k if k contains expected in the Hash, and return whether it inserted anything.
 */
 func (self *Hash) PutIfPresent(k Hashable, v Thing, expected Equalable) (rval bool) {
-	newEntry := newRealEntry(k, v)
+	defer self.pin().Unpin()
+	hashCode := k.HashCode()
+	w := self.lockWatch(hashCode)
+	defer w.unlock(hashCode)
+	table := self.getTable()
+	var newValue unsafe.Pointer
 	for {
-		bucket := self.getBucketByHashCode(newEntry.hashCode)
-		hit := (*hashHit)(bucket.search(newEntry))
-		if hit2 := hit.search(newEntry); hit2.element == nil {
-			break
-		} else {
-			oldEntry := hit2.element.value.(*entry)
-			oldValuePtr := atomic.LoadPointer(&oldEntry.value)
-			if expected.Equals(*(*Thing)(oldValuePtr)) {
-				if atomic.CompareAndSwapPointer(&oldEntry.value, oldValuePtr, unsafe.Pointer(newEntry.value)) {
-					rval = true
-					break
-				}
-			} else {
-				break
-			}
+		_, match, _ := table.find(hashCode, k)
+		if match == nil {
+			return false
+		}
+		oldEntry := match.value.(*entry)
+		oldValuePtr := atomic.LoadPointer(&oldEntry.value)
+		if !expected.Equals(*(*Thing)(oldValuePtr)) {
+			return false
+		}
+		if newValue == nil {
+			newValue = unsafe.Pointer(&v)
+		}
+		if atomic.CompareAndSwapPointer(&oldEntry.value, oldValuePtr, newValue) {
+			w.notify(HashEvent{HashPut, k, *(*Thing)(oldValuePtr), true, v})
+			return true
 		}
 	}
-	return
 }
 
 /*
  PutIfMissing will insert v under k if k was missing from the Hash, and return whether it inserted anything.
 */
 func (self *Hash) PutIfMissing(k Hashable, v Thing) (rval bool) {
-	newEntry := newRealEntry(k, v)
-	alloc := &element{}
+	defer self.pin().Unpin()
+	hashCode := k.HashCode()
+	w := self.lockWatch(hashCode)
+	defer w.unlock(hashCode)
+	table := self.getTable()
+	var node *hashNode
 	for {
-		bucket := self.getBucketByHashCode(newEntry.hashCode)
-		hit := (*hashHit)(bucket.search(newEntry))
-		if hit2 := hit.search(newEntry); hit2.element == nil {
-			if hit2.left.addBefore(newEntry, alloc, hit2.right) {
-				self.addSize(1)
-				return true
+		left, match, right := table.find(hashCode, k)
+		if match != nil {
+			if node != nil {
+				self.recycle(node)
 			}
-		} else {
-			break
+			return false
+		}
+		if node == nil {
+			node = self.newNode(hashCode, k, unsafe.Pointer(&v))
+		}
+		if left.addBefore(&node.entry, &node.element, right) {
+			self.addSize(table, 1)
+			w.notify(HashEvent{HashPut, k, nil, false, v})
+			return true
 		}
 	}
-	return
+}
+func (self *Hash) putIfMissing(newEntry *entry) (rval bool) {
+	alloc := &element{}
+	table := self.getTable()
+	for {
+		left, match, right := table.find(newEntry.hashCode, newEntry.key)
+		if match != nil {
+			return false
+		}
+		if left.addBefore(newEntry, alloc, right) {
+			self.addSize(table, 1)
+			return true
+		}
+	}
+}
+
+/*
+ recycle returns a hashNode that was never added to the list to the pool, if the Hash is pooled.
+*/
+func (self *Hash) recycle(n *hashNode) {
+	if self.pool != nil {
+		self.pool.nodes.Put(n)
+	}
 }
 
 /*
@@ -345,25 +561,32 @@ func (self *Hash) PutIfMissing(k Hashable, v Thing) (rval bool) {
  Use this when you already have the hash code and don't want to force gotomic to calculate it again.
 */
 func (self *Hash) PutHC(hashCode uint32, k Hashable, v Thing) (rval Thing, ok bool) {
-	newEntry := newRealEntryWithHashCode(k, v, hashCode)
-	alloc := &element{}
+	defer self.pin().Unpin()
+	w := self.lockWatch(hashCode)
+	defer w.unlock(hashCode)
+	newValue := unsafe.Pointer(&v)
+	table := self.getTable()
+	var node *hashNode
 	for {
-		bucket := self.getBucketByHashCode(newEntry.hashCode)
-		hit := (*hashHit)(bucket.search(newEntry))
-		if hit2 := hit.search(newEntry); hit2.element == nil {
-			if hit2.left.addBefore(newEntry, alloc, hit2.right) {
-				self.addSize(1)
-				break
+		left, match, right := table.find(hashCode, k)
+		if match != nil {
+			if node != nil {
+				self.recycle(node)
 			}
-		} else {
-			oldEntry := hit2.element.value.(*entry)
-			rval = oldEntry.val()
-			ok = true
-			atomic.StorePointer(&oldEntry.value, newEntry.value)
-			break
+			oldEntry := match.value.(*entry)
+			rval = *(*Thing)(atomic.SwapPointer(&oldEntry.value, newValue))
+			w.notify(HashEvent{HashPut, k, rval, true, v})
+			return rval, true
+		}
+		if node == nil {
+			node = self.newNode(hashCode, k, newValue)
+		}
+		if left.addBefore(&node.entry, &node.element, right) {
+			self.addSize(table, 1)
+			w.notify(HashEvent{HashPut, k, nil, false, v})
+			return nil, false
 		}
 	}
-	return
 }
 
 /*
@@ -372,13 +595,13 @@ func (self *Hash) PutHC(hashCode uint32, k Hashable, v Thing) (rval Thing, ok bo
 func (self *Hash) Put(k Hashable, v Thing) (rval Thing, ok bool) {
 	return self.PutHC(k.HashCode(), k, v)
 }
-func (self *Hash) addSize(i int) {
-	atomic.AddInt64(&self.size, int64(i))
-	if atomic.LoadInt64(&self.size) > int64(self.loadFactor*float64(uint32(1)<<atomic.LoadUint32(&self.exponent))) {
-		self.grow()
+func (self *Hash) addSize(table *hashTable, i int) {
+	atomic.AddInt64(&table.size, int64(i))
+	if atomic.LoadInt64(&table.size) > int64(self.loadFactor*float64(uint32(1)<<atomic.LoadUint32(&table.exponent))) {
+		table.grow()
 	}
 }
-func (self *Hash) grow() {
+func (self *hashTable) grow() {
 	oldExponent := atomic.LoadUint32(&self.exponent)
 	newExponent := oldExponent + 1
 	newBuckets := make([]unsafe.Pointer, 1<<oldExponent)
@@ -386,14 +609,14 @@ func (self *Hash) grow() {
 		atomic.CompareAndSwapUint32(&self.exponent, oldExponent, newExponent)
 	}
 }
-func (self *Hash) getPreviousBucketIndex(bucketKey uint32) uint32 {
+func (self *hashTable) getPreviousBucketIndex(bucketKey uint32) uint32 {
 	exp := atomic.LoadUint32(&self.exponent)
 	return reverse(((bucketKey >> (max_exponent - exp)) - 1) << (max_exponent - exp))
 }
-func (self *Hash) getBucketByHashCode(hashCode uint32) *element {
+func (self *hashTable) getBucketByHashCode(hashCode uint32) *element {
 	return self.getBucketByIndex(hashCode & ((1 << atomic.LoadUint32(&self.exponent)) - 1))
 }
-func (self *Hash) getBucketIndices(index uint32) (superIndex, subIndex uint32) {
+func (self *hashTable) getBucketIndices(index uint32) (superIndex, subIndex uint32) {
 	if index > 0 {
 		superIndex = log2(index)
 		subIndex = index - (1 << superIndex)
@@ -401,7 +624,7 @@ func (self *Hash) getBucketIndices(index uint32) (superIndex, subIndex uint32) {
 	}
 	return
 }
-func (self *Hash) getBucketByIndex(index uint32) (bucket *element) {
+func (self *hashTable) getBucketByIndex(index uint32) (bucket *element) {
 	superIndex, subIndex := self.getBucketIndices(index)
 	subBuckets := *(*[]unsafe.Pointer)(atomic.LoadPointer(&self.buckets[superIndex]))
 	for {
@@ -208,6 +208,69 @@ func TestHashConcurrency(t *testing.T) {
 	assertMappy(t, h, cmp)
 }
 
+func TestPooledHashConcurrency(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	h := NewPooledHash(NewEpoch())
+	cmp := make(map[Hashable]Thing)
+	for i := 0; i < 1000; i++ {
+		k := StringKey(fmt.Sprint("StringKey", i))
+		v := fmt.Sprint("value", i)
+		h.Put(k, v)
+		cmp[k] = v
+	}
+	assertMappy(t, h, cmp)
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleHash(t, h, fmt.Sprint("fiddler-", i, "-"), do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	assertMappy(t, h, cmp)
+}
+
+func TestHashAllocs(t *testing.T) {
+	h := NewHash()
+	var k Hashable = hashInt(1000)
+	var v Thing = "v"
+	h.Put(k, v)
+	if n := testing.AllocsPerRun(100, func() { h.Get(k) }); n != 0 {
+		t.Error(h, "should not allocate when getting, but allocated", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { h.Put(k, v) }); n != 1 {
+		t.Error(h, "should allocate only the new value when replacing, but allocated", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { h.Delete(k); h.Put(k, v) }); n != 3 {
+		t.Error(h, "should allocate only the deletion marker, the new node and the new value when deleting and putting, but allocated", n)
+	}
+}
+
+func putDeleteAction(b *testing.B, m *Hash) {
+	b.ReportAllocs()
+	keys := make([]Hashable, 1024)
+	for i := range keys {
+		keys[i] = hashInt(i)
+	}
+	var v Thing = "v"
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := keys[i%len(keys)]
+		m.Put(k, v)
+		m.Get(k)
+		m.Delete(k)
+	}
+}
+
+func BenchmarkHashPutGetDelete(b *testing.B) {
+	putDeleteAction(b, NewHash())
+}
+
+func BenchmarkPooledHashPutGetDelete(b *testing.B) {
+	putDeleteAction(b, NewPooledHash(NewEpoch()))
+}
+
 func TestHashEach(t *testing.T) {
 	h := NewHash()
 	h.Put(StringKey("a"), "1")
@@ -305,3 +368,41 @@ func TestPutDelete(t *testing.T) {
 	}
 	assertMappy(t, h, map[Hashable]Thing{})
 }
+
+func TestHashClear(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < 100; i++ {
+		h.Put(IntKey(i), i)
+	}
+	assertMappy(t, h, h.ToMap())
+	h.Clear()
+	assertMappy(t, h, map[Hashable]Thing{})
+	h.Put(StringKey("a"), "b")
+	assertMappy(t, h, map[Hashable]Thing{StringKey("a"): "b"})
+}
+
+func TestHashConcClear(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	h := NewHash()
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func(x int) {
+			<-do
+			for j := 0; j < 10000; j++ {
+				h.Put(StringKey(fmt.Sprint(x, "-", j)), j)
+			}
+			done <- true
+		}(i)
+	}
+	close(do)
+	for i := 0; i < 10; i++ {
+		h.Clear()
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	assertMappy(t, h, h.ToMap())
+	h.Clear()
+	assertMappy(t, h, map[Hashable]Thing{})
+}
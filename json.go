@@ -0,0 +1,201 @@
+package gotomic
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unsafe"
+)
+
+/*
+ JSONKeyDecoder turns a JSON object key produced by MarshalJSON back into a key.
+*/
+type JSONKeyDecoder func(s string) (Thing, error)
+
+/*
+ StringKeyDecoder decodes JSON object keys as StringKeys.
+*/
+func StringKeyDecoder(s string) (Thing, error) {
+	return StringKey(s), nil
+}
+
+/*
+ IntKeyDecoder decodes JSON object keys as IntKeys.
+*/
+func IntKeyDecoder(s string) (Thing, error) {
+	i, err := strconv.Atoi(s)
+	return IntKey(i), err
+}
+
+/*
+ encodeJSONKey returns the JSON object key for k, which must be a StringKey, an IntKey or implement encoding.TextMarshaler.
+*/
+func encodeJSONKey(k Thing) (string, error) {
+	switch key := k.(type) {
+	case StringKey:
+		return string(key), nil
+	case IntKey:
+		return strconv.Itoa(int(key)), nil
+	case encoding.TextMarshaler:
+		b, err := key.MarshalText()
+		return string(b), err
+	}
+	return "", fmt.Errorf("%#v of type %T can't be a JSON object key, it has to be a StringKey, an IntKey or an encoding.TextMarshaler", k, k)
+}
+
+/*
+ writeJSONMember writes k and v as a member of a JSON object to buf, preceded by a comma unless it is the first member.
+
+ seen contains the JSON object keys already written, since keys of different types may encode to the same JSON object key.
+*/
+func writeJSONMember(buf *bytes.Buffer, seen map[string]bool, k, v Thing) (err error) {
+	key, err := encodeJSONKey(k)
+	if err != nil {
+		return
+	}
+	if seen[key] {
+		return fmt.Errorf("%#v of type %T encodes to the JSON object key %q, which another key already encoded to", k, k, key)
+	}
+	seen[key] = true
+	encodedKey, err := json.Marshal(key)
+	if err != nil {
+		return
+	}
+	encodedValue, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if buf.Len() > 1 {
+		buf.WriteByte(',')
+	}
+	buf.Write(encodedKey)
+	buf.WriteByte(':')
+	buf.Write(encodedValue)
+	return
+}
+
+/*
+ decodeJSONObject decodes data as a JSON object, and returns its keys decoded by keys and its values.
+*/
+func decodeJSONObject(data []byte, keys JSONKeyDecoder) (rval map[Thing]Thing, err error) {
+	var m map[string]Thing
+	if err = json.Unmarshal(data, &m); err != nil {
+		return
+	}
+	rval = make(map[Thing]Thing, len(m))
+	for s, v := range m {
+		var k Thing
+		if k, err = keys(s); err != nil {
+			return
+		}
+		rval[k] = v
+	}
+	return
+}
+
+/*
+ MarshalJSON returns a snapshot of the Hash as a JSON object.
+
+ The keys must be StringKeys, IntKeys or implement encoding.TextMarshaler, and no two keys may encode to the same
+ JSON object key (like StringKey("1") and IntKey(1) do).
+*/
+func (self *Hash) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBufferString("{")
+	seen := make(map[string]bool)
+	var err error
+	self.Each(func(k Hashable, v Thing) bool {
+		err = writeJSONMember(buf, seen, k, v)
+		return err != nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+/*
+ DecodeJSON puts the members of the JSON object in data in the Hash, using keys to decode the keys.
+
+ The values will be whatever encoding/json decodes into an interface{}.
+*/
+func (self *Hash) DecodeJSON(data []byte, keys JSONKeyDecoder) error {
+	m, err := decodeJSONObject(data, keys)
+	if err != nil {
+		return err
+	}
+	if self.table == nil {
+		self.table = unsafe.Pointer(newHashTable())
+		self.loadFactor = default_load_factor
+	}
+	for k, v := range m {
+		hashable, ok := k.(Hashable)
+		if !ok {
+			return fmt.Errorf("%#v is not Hashable", k)
+		}
+		self.Put(hashable, v)
+	}
+	return nil
+}
+
+/*
+ UnmarshalJSON puts the members of the JSON object in data in the Hash, with StringKeys as keys.
+
+ Use DecodeJSON to decode other kinds of keys.
+*/
+func (self *Hash) UnmarshalJSON(data []byte) error {
+	return self.DecodeJSON(data, StringKeyDecoder)
+}
+
+/*
+ MarshalJSON returns a snapshot of the Treap as a JSON object, with the members in order.
+
+ The keys must be StringKeys, IntKeys or implement encoding.TextMarshaler, and no two keys may encode to the same
+ JSON object key (like StringKey("1") and IntKey(1) do).
+*/
+func (self *Treap) MarshalJSON() ([]byte, error) {
+	keys, values := self.ToSlice()
+	buf := bytes.NewBufferString("{")
+	seen := make(map[string]bool)
+	for index, k := range keys {
+		if err := writeJSONMember(buf, seen, k, values[index]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+/*
+ DecodeJSON puts the members of the JSON object in data in the Treap, using keys to decode the keys.
+
+ The values will be whatever encoding/json decodes into an interface{}.
+*/
+func (self *Treap) DecodeJSON(data []byte, keys JSONKeyDecoder) error {
+	m, err := decodeJSONObject(data, keys)
+	if err != nil {
+		return err
+	}
+	if self.handle == nil {
+		self.handle = NewHandle(&treap{})
+	}
+	for k, v := range m {
+		comparable, ok := k.(Comparable)
+		if !ok {
+			return fmt.Errorf("%#v is not Comparable", k)
+		}
+		self.Put(comparable, v)
+	}
+	return nil
+}
+
+/*
+ UnmarshalJSON puts the members of the JSON object in data in the Treap, with StringKeys as keys.
+
+ Use DecodeJSON to decode other kinds of keys.
+*/
+func (self *Treap) UnmarshalJSON(data []byte) error {
+	return self.DecodeJSON(data, StringKeyDecoder)
+}
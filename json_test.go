@@ -0,0 +1,76 @@
+package gotomic
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestHashJSON(t *testing.T) {
+	h := NewHash()
+	h.Put(StringKey("a"), 1)
+	h.Put(StringKey("b"), []string{"x"})
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string]interface{}{"a": 1.0, "b": []interface{}{"x"}}) {
+		t.Error(h, "should be encoded as an object, but was", string(b))
+	}
+	var h2 Hash
+	if err := json.Unmarshal(b, &h2); err != nil {
+		t.Fatal(err)
+	}
+	assertMappy(t, &h2, map[Hashable]Thing{StringKey("a"): 1.0, StringKey("b"): []interface{}{"x"}})
+
+	h3 := NewHash()
+	h3.Put(IntKey(3), "c")
+	if b, err = json.Marshal(h3); err != nil || string(b) != `{"3":"c"}` {
+		t.Error(h3, "should be encoded as {\"3\":\"c\"}, but was", string(b), err)
+	}
+	h4 := NewHash()
+	if err := h4.DecodeJSON(b, IntKeyDecoder); err != nil {
+		t.Fatal(err)
+	}
+	assertMappy(t, h4, map[Hashable]Thing{IntKey(3): "c"})
+
+	h5 := NewHash()
+	h5.Put(hashInt(1), "a")
+	if _, err := json.Marshal(h5); err == nil {
+		t.Error(h5, "should not be encodable with hashInt keys")
+	}
+	h3.Put(StringKey("3"), "d")
+	if b, err := json.Marshal(h3); err == nil {
+		t.Error(h3, "should not be encodable with both IntKey(3) and StringKey(\"3\"), but was", string(b))
+	}
+}
+
+func TestTreapJSON(t *testing.T) {
+	treap := NewTreap()
+	for _, k := range []int{10, 2, 33} {
+		treap.Put(IntKey(k), k)
+	}
+	b, err := json.Marshal(treap)
+	if err != nil || string(b) != `{"2":2,"10":10,"33":33}` {
+		t.Error(treap, "should be encoded in order, but was", string(b), err)
+	}
+	treap2 := NewTreap()
+	if err := treap2.DecodeJSON(b, IntKeyDecoder); err != nil {
+		t.Fatal(err)
+	}
+	keys, values := treap2.ToSlice()
+	if !reflect.DeepEqual(keys, []Comparable{IntKey(2), IntKey(10), IntKey(33)}) || !reflect.DeepEqual(values, []Thing{2.0, 10.0, 33.0}) {
+		t.Error(treap2, "should contain 2, 10 and 33, but contained", keys, values)
+	}
+	var treap3 Treap
+	if err := json.Unmarshal([]byte(`{"b":1,"a":2}`), &treap3); err != nil {
+		t.Fatal(err)
+	}
+	if k, v, ok := treap3.Min(); !ok || k != StringKey("a") || v != 2.0 {
+		t.Error(treap3, "should have min a, but had", k, v, ok)
+	}
+}
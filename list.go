@@ -2,8 +2,10 @@ package gotomic
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -11,6 +13,11 @@ var deletedElement = "deleted"
 
 type ListIterator func(t Thing) bool
 
+/*
+ ListPredicate is used to select elements to remove from a List.
+*/
+type ListPredicate func(t Thing) bool
+
 type hit struct {
 	left    *element
 	element *element
@@ -39,11 +46,12 @@ var list_head = "LIST_HEAD"
 */
 type List struct {
 	*element
-	size int64
+	size   int64
+	signal signal
 }
 
 func NewList() *List {
-	return &List{&element{nil, &list_head}, 0}
+	return &List{&element{nil, &list_head}, 0, signal{}}
 }
 
 /*
@@ -52,6 +60,7 @@ func NewList() *List {
 func (self *List) Push(t Thing) {
 	self.element.add(t)
 	atomic.AddInt64(&self.size, 1)
+	self.signal.notify()
 }
 
 /*
@@ -65,6 +74,27 @@ func (self *List) Pop() (rval Thing, ok bool) {
 	return nil, false
 }
 
+/*
+ PopWait removes and returns the top of the List, waiting for something to be pushed if it is empty, until ctx is done.
+*/
+func (self *List) PopWait(ctx context.Context) (rval Thing, err error) {
+	err = self.signal.wait(ctx, func() (ok bool) {
+		rval, ok = self.Pop()
+		return
+	})
+	return
+}
+
+/*
+ TryPopTimeout removes and returns the top of the List, waiting at most timeout for something to be pushed if it is empty.
+*/
+func (self *List) TryPopTimeout(timeout time.Duration) (rval Thing, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rval, err := self.PopWait(ctx)
+	return rval, err == nil
+}
+
 /*
  Each will run i on each element.
 
@@ -77,6 +107,72 @@ func (self *List) Each(i ListIterator) bool {
 	return n != nil && n.each(i)
 }
 
+/*
+ ListCursor is a position in a List that can be used to edit the List while iterating over it.
+
+ It stays valid when the List is modified concurrently, even if the element it points to is removed.
+*/
+type ListCursor struct {
+	list     *List
+	previous *element
+	current  *element
+}
+
+/*
+ Cursor returns a ListCursor positioned before the first element of the List.
+*/
+func (self *List) Cursor() *ListCursor {
+	return &ListCursor{self, nil, self.element}
+}
+
+/*
+ Next moves the ListCursor to the next element that isn't removed, and returns whether there was one.
+*/
+func (self *ListCursor) Next() bool {
+	if self.current == nil {
+		return false
+	}
+	self.previous, self.current = self.current, self.current.next()
+	return self.current != nil
+}
+
+/*
+ Value returns the value of the element the ListCursor points to.
+*/
+func (self *ListCursor) Value() Thing {
+	if self.current == nil || self.current == self.list.element {
+		return nil
+	}
+	return self.current.value
+}
+
+/*
+ InsertAfter adds t right after the element the ListCursor points to (or first in the List, if Next hasn't been called yet)
+ and returns true, unless the element has been removed.
+*/
+func (self *ListCursor) InsertAfter(t Thing) bool {
+	if self.current == nil || !self.current.add(t) {
+		return false
+	}
+	atomic.AddInt64(&self.list.size, 1)
+	self.list.signal.notify()
+	return true
+}
+
+/*
+ Remove removes the element the ListCursor points to, and returns whether we were the ones to remove it.
+
+ The ListCursor can still be used to move to the next element afterwards.
+*/
+func (self *ListCursor) Remove() bool {
+	if self.current == nil || self.current == self.list.element || !self.current.doRemove() {
+		return false
+	}
+	atomic.AddInt64(&self.list.size, -1)
+	self.previous.next()
+	return true
+}
+
 func (self *List) String() string {
 	return fmt.Sprint(self.ToSlice())
 }
@@ -107,6 +203,58 @@ func (self *List) Size() int {
 func (self *List) Inject(c Comparable) {
 	self.element.inject(c)
 	atomic.AddInt64(&self.size, 1)
+	self.signal.notify()
+}
+
+/*
+ InjectIfMissing injects c into the List like Inject, unless there already is an element matching c (c.Compare(element) == 0).
+
+ Returns whether c was injected.
+*/
+func (self *List) InjectIfMissing(c Comparable) bool {
+	if self.element.injectIfMissing(c) {
+		atomic.AddInt64(&self.size, 1)
+		self.signal.notify()
+		return true
+	}
+	return false
+}
+
+/*
+ InjectOrReplace injects c into the List like Inject, unless there already is an element matching c (c.Compare(element) == 0),
+ in which case c replaces it.
+
+ Returns the replaced value and whether anything was replaced.
+*/
+func (self *List) InjectOrReplace(c Comparable) (old Thing, replaced bool) {
+	if old, replaced = self.element.injectOrReplace(c); !replaced {
+		atomic.AddInt64(&self.size, 1)
+		self.signal.notify()
+	}
+	return
+}
+
+/*
+ Remove removes and returns the first element in the List that matches c (c.Compare(element) == 0).
+*/
+func (self *List) Remove(c Comparable) (rval Thing, ok bool) {
+	removed := self.element.removeIf(func(t Thing) bool {
+		return c.Compare(t) == 0
+	}, true)
+	if len(removed) > 0 {
+		atomic.AddInt64(&self.size, -1)
+		return removed[0], true
+	}
+	return nil, false
+}
+
+/*
+ RemoveIf removes all elements in the List that p returns true for, and returns the number of elements removed.
+*/
+func (self *List) RemoveIf(p ListPredicate) int {
+	removed := self.element.removeIf(p, false)
+	atomic.AddInt64(&self.size, -int64(len(removed)))
+	return len(removed)
 }
 
 type element struct {
@@ -248,6 +396,59 @@ func (self *element) inject(c Comparable) {
 		}
 	}
 }
+
+/*
+ injectIfMissing injects c into self like inject, unless there already is a matching value (c.Compare(value) == 0).
+
+ Since addBefore fails if anything was added between the left and right of our search, two concurrent calls
+ can never both inject matching values.
+*/
+func (self *element) injectIfMissing(c Comparable) bool {
+	alloc := &element{}
+	for {
+		hit := self.search(c)
+		if hit.element != nil {
+			return false
+		}
+		if hit.left.addBefore(c, alloc, hit.right) {
+			return true
+		}
+	}
+}
+
+/*
+ injectOrReplace injects c into self like injectIfMissing, but if there is a matching value it will replace it and
+ return it.
+
+ Since element values can't be changed atomically, a replacement is done by adding c right after the match and then
+ removing the match. Whoever removes the match wins, and everyone else removes their own added element and tries again.
+ This means the matching value is never missing from the list, but concurrent readers may see both values for a while.
+
+ Since the added element matches c it can itself be removed or replaced by others before we get to remove it, in which
+ case c was part of the list for a while, and we count it as injected.
+*/
+func (self *element) injectOrReplace(c Comparable) (old Thing, replaced bool) {
+	alloc := &element{}
+	for {
+		hit := self.search(c)
+		if hit.element == nil {
+			if hit.left.addBefore(c, alloc, hit.right) {
+				return nil, false
+			}
+		} else if hit.element.addBefore(c, alloc, hit.element.next()) {
+			if hit.element.doRemove() {
+				hit.left.next()
+				return hit.element.value, true
+			}
+			if !alloc.doRemove() {
+				hit.left.next()
+				return nil, false
+			}
+			hit.left.next()
+			alloc = &element{}
+		}
+	}
+}
 func (self *element) ToSlice() []Thing {
 	rval := make([]Thing, 0)
 	current := self
@@ -335,6 +536,34 @@ func (self *element) verify() (err error) {
 func (self *element) doRemove() bool {
 	return self.add(&deletedElement)
 }
+
+/*
+ removeIf removes the elements after self that p returns true for, stopping after the first one if once is true,
+ and returns the values it removed.
+
+ Elements someone else removes before we do are skipped.
+*/
+func (self *element) removeIf(p ListPredicate, once bool) (rval []Thing) {
+	left := self
+	current := self.next()
+	for current != nil {
+		if p(current.value) && current.doRemove() {
+			rval = append(rval, current.value)
+			if once {
+				left.next()
+				return
+			}
+			/*
+			 Unlink the removed element, and continue with whatever is behind it now.
+			*/
+			current = left.next()
+		} else {
+			left = current
+			current = current.next()
+		}
+	}
+	return
+}
 func (self *element) remove() (rval Thing, ok bool) {
 	n := self.next()
 	for {
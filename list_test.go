@@ -1,6 +1,7 @@
 package gotomic
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -369,3 +370,273 @@ func TestConcInjectAndSearch(t *testing.T) {
 		}
 	}
 }
+
+func TestListPopWait(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	l := NewList()
+	if v, ok := l.TryPopTimeout(time.Millisecond); ok {
+		t.Error(l, "should not pop anything, but popped", v)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan Thing)
+	stopped := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func() {
+			for {
+				v, err := l.PopWait(ctx)
+				if err != nil {
+					stopped <- true
+					return
+				}
+				results <- v
+			}
+		}()
+	}
+	n := 1000
+	sum := 0
+	for i := 1; i <= n; i++ {
+		l.Push(i)
+		sum += i
+	}
+	for i := 0; i < n; i++ {
+		sum -= (<-results).(int)
+	}
+	if sum != 0 {
+		t.Error("waiting poppers should have popped everything pushed, but missed", sum)
+	}
+	cancel()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-stopped
+	}
+	if _, err := l.PopWait(ctx); err != context.Canceled {
+		t.Error(l, "should be canceled, but got", err)
+	}
+}
+
+func TestListRemove(t *testing.T) {
+	l := NewList()
+	for _, v := range []c{3, 1, 4, 1, 5, 9, 2, 6} {
+		l.Inject(v)
+	}
+	assertListy(t, l, []Thing{c(1), c(1), c(2), c(3), c(4), c(5), c(6), c(9)})
+	if v, ok := l.Remove(c(4)); !ok || v != c(4) {
+		t.Error(l, "should remove 4, but removed", v, ok)
+	}
+	if v, ok := l.Remove(c(7)); ok {
+		t.Error(l, "should not remove anything, but removed", v)
+	}
+	if v, ok := l.Remove(c(1)); !ok || v != c(1) {
+		t.Error(l, "should remove 1, but removed", v, ok)
+	}
+	assertListy(t, l, []Thing{c(1), c(2), c(3), c(5), c(6), c(9)})
+	if n := l.RemoveIf(func(t Thing) bool { return t.(c)%2 == 1 }); n != 4 {
+		t.Error(l, "should remove 4 odd elements, but removed", n)
+	}
+	assertListy(t, l, []Thing{c(2), c(6)})
+	if n := l.RemoveIf(func(t Thing) bool { return true }); n != 2 {
+		t.Error(l, "should remove 2 elements, but removed", n)
+	}
+	assertListy(t, l, []Thing{})
+}
+
+func fiddleRemove(t *testing.T, l *List, x int, do, done chan bool) {
+	<-do
+	n := 1000
+	for i := 0; i < n; i++ {
+		l.Inject(c(i*runtime.NumCPU() + x))
+	}
+	for i := 0; i < n; i++ {
+		v := c(i*runtime.NumCPU() + x)
+		if r, ok := l.Remove(v); !ok || r != v {
+			t.Error(l, "should remove", v, "but removed", r, ok)
+		}
+	}
+	done <- true
+}
+
+func TestListConcRemove(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	l := NewList()
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleRemove(t, l, i, do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	assertListy(t, l, []Thing{})
+}
+
+func TestListInjectIfMissing(t *testing.T) {
+	l := NewList()
+	for _, v := range []c{3, 1, 4, 1, 5} {
+		l.InjectIfMissing(v)
+	}
+	assertListy(t, l, []Thing{c(1), c(3), c(4), c(5)})
+	if l.InjectIfMissing(c(4)) {
+		t.Error(l, "should not inject 4 again")
+	}
+	if !l.InjectIfMissing(c(2)) {
+		t.Error(l, "should inject 2")
+	}
+	assertListy(t, l, []Thing{c(1), c(2), c(3), c(4), c(5)})
+}
+
+type keyed struct {
+	key   int
+	value string
+}
+
+func (self keyed) Compare(t Thing) int {
+	return c(self.key).Compare(c(t.(keyed).key))
+}
+
+func TestListInjectOrReplace(t *testing.T) {
+	l := NewList()
+	if old, replaced := l.InjectOrReplace(keyed{1, "a"}); replaced {
+		t.Error(l, "should not replace anything, but replaced", old)
+	}
+	l.InjectOrReplace(keyed{2, "b"})
+	if old, replaced := l.InjectOrReplace(keyed{1, "c"}); !replaced || old != (keyed{1, "a"}) {
+		t.Error(l, "should replace {1 a}, but replaced", old, replaced)
+	}
+	assertListy(t, l, []Thing{keyed{1, "c"}, keyed{2, "b"}})
+}
+
+func fiddleUnique(t *testing.T, l *List, x int, do, done chan bool) {
+	<-do
+	for i := 0; i < 1000; i++ {
+		if x%2 == 0 {
+			l.InjectIfMissing(keyed{i % 100, fmt.Sprint(x)})
+		} else {
+			l.InjectOrReplace(keyed{i % 100, fmt.Sprint(x)})
+		}
+	}
+	done <- true
+}
+
+func TestListConcUnique(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	l := NewList()
+	do := make(chan bool)
+	done := make(chan bool)
+	n := runtime.NumCPU()
+	if n < 2 {
+		n = 2
+	}
+	for i := 0; i < n; i++ {
+		go fiddleUnique(t, l, i, do, done)
+	}
+	close(do)
+	for i := 0; i < n; i++ {
+		<-done
+	}
+	if l.Size() != 100 {
+		t.Error(l, "should have size 100, but had", l.Size())
+	}
+	sl := l.ToSlice()
+	if len(sl) != 100 {
+		t.Fatal(l, "should have 100 elements, but had", len(sl))
+	}
+	for i, v := range sl {
+		if v.(keyed).key != i {
+			t.Error(l, "should have", i, "at", i, "but had", v)
+		}
+	}
+}
+
+func fiddleReplaceRemove(t *testing.T, l *List, x int, do, done chan bool) {
+	<-do
+	for i := 0; i < 1000; i++ {
+		if x%2 == 0 {
+			l.InjectOrReplace(keyed{i % 3, fmt.Sprint(x)})
+		} else {
+			l.Remove(keyed{i % 3, ""})
+		}
+		runtime.Gosched()
+	}
+	done <- true
+}
+
+func TestListConcReplaceRemove(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	for round := 0; round < 10; round++ {
+		l := NewList()
+		do := make(chan bool)
+		done := make(chan bool)
+		for i := 0; i < 8; i++ {
+			go fiddleReplaceRemove(t, l, i, do, done)
+		}
+		close(do)
+		for i := 0; i < 8; i++ {
+			<-done
+		}
+		if sl := l.ToSlice(); l.Size() != len(sl) {
+			t.Fatal(l, "should have size", len(sl), "in round", round, "but had", l.Size())
+		}
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	l := NewList()
+	for _, v := range []c{5, 4, 3, 2, 1} {
+		l.Push(v)
+	}
+	cursor := l.Cursor()
+	if cursor.Remove() {
+		t.Error(l, "should not be able to remove the head")
+	}
+	cursor.InsertAfter(c(0))
+	for cursor.Next() {
+		switch v := cursor.Value().(c); {
+		case v%2 == 0:
+			if !cursor.Remove() {
+				t.Error(l, "should be able to remove", v)
+			}
+			if cursor.InsertAfter(c(10)) {
+				t.Error(l, "should not be able to insert after removed", v)
+			}
+		case v == 3:
+			cursor.InsertAfter(c(30))
+			cursor.Next()
+		}
+	}
+	assertListy(t, l, []Thing{c(1), c(3), c(30), c(5)})
+	if cursor.Next() || cursor.Value() != nil {
+		t.Error(l, "should be at the end, but had", cursor.Value())
+	}
+}
+
+func fiddleCursor(t *testing.T, l *List, x int, do chan bool, done chan int) {
+	<-do
+	removed := 0
+	for i := 0; i < 1000; i++ {
+		l.Push(c(x))
+		for cursor := l.Cursor(); cursor.Next(); {
+			if cursor.Value() == c(x) && cursor.Remove() {
+				removed++
+				break
+			}
+		}
+	}
+	done <- removed
+}
+
+func TestListConcCursor(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	l := NewList()
+	do := make(chan bool)
+	done := make(chan int)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleCursor(t, l, i, do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		if removed := <-done; removed != 1000 {
+			t.Error(l, "should have removed 1000 elements, but removed", removed)
+		}
+	}
+	assertListy(t, l, []Thing{})
+}
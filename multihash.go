@@ -0,0 +1,193 @@
+package gotomic
+
+import (
+	"fmt"
+)
+
+/*
+ searchValue will continue searching from self for an entry with the same key as cmp and a value equal to v.
+
+ Values under the same key are kept ordered by their Compare method, so the search will stop at the first
+ entry under the same key that should be after v.
+*/
+func (self *hashHit) searchValue(cmp *entry, v Comparable) (rval *hashHit) {
+	rval = &hashHit{self.left, self.element, self.right}
+	for {
+		if rval.element == nil {
+			break
+		}
+		rval.right = rval.element.next()
+		e := rval.element.value.(*entry)
+		if e.hashKey != cmp.hashKey {
+			rval.right = rval.element
+			rval.element = nil
+			break
+		}
+		if cmp.key.Equals(e.key) {
+			c := v.Compare(e.val())
+			if c == 0 {
+				break
+			}
+			if c < 0 {
+				rval.right = rval.element
+				rval.element = nil
+				break
+			}
+		}
+		rval.left = rval.element
+		rval.element = rval.left.next()
+		rval.right = nil
+	}
+	return
+}
+
+/*
+ MultiHash is a hash table that can keep multiple values under each key.
+
+ It uses the same split-ordered list as Hash, but will keep one entry for each key and value pair, ordered
+ by the Compare method of the values. The same value can only exist once under each key.
+
+ It is thread safe and non-blocking.
+*/
+type MultiHash Hash
+
+func NewMultiHash() *MultiHash {
+	return (*MultiHash)(NewHash())
+}
+func (self *MultiHash) hash() *Hash {
+	return (*Hash)(self)
+}
+
+/*
+ Size returns the total number of values in the MultiHash.
+*/
+func (self *MultiHash) Size() int {
+	return self.hash().Size()
+}
+
+/*
+ Clear removes all keys and values from the MultiHash, like Hash#Clear.
+*/
+func (self *MultiHash) Clear() {
+	self.hash().Clear()
+}
+
+/*
+ Verify the integrity of the MultiHash.
+*/
+func (self *MultiHash) Verify() error {
+	return self.hash().Verify()
+}
+
+/*
+ Each will run i on each key and value.
+
+ It returns true if the iteration was interrupted.
+ This is the case when one of the HashIterator calls returned true, indicating
+ the iteration should be stopped.
+*/
+func (self *MultiHash) Each(i HashIterator) bool {
+	return self.hash().Each(i)
+}
+
+/*
+ ToMap returns a map[Hashable][]Thing that is logically identical to the MultiHash.
+*/
+func (self *MultiHash) ToMap() map[Hashable][]Thing {
+	rval := make(map[Hashable][]Thing)
+	self.Each(func(k Hashable, v Thing) bool {
+		rval[k] = append(rval[k], v)
+		return false
+	})
+	return rval
+}
+func (self *MultiHash) String() string {
+	return fmt.Sprint(self.ToMap())
+}
+
+/*
+ Add will insert v under k and return whether it was missing before.
+*/
+func (self *MultiHash) Add(k Hashable, v Comparable) bool {
+	newEntry := newRealEntry(k, v)
+	alloc := &element{}
+	table := self.hash().getTable()
+	for {
+		bucket := table.getBucketByHashCode(newEntry.hashCode)
+		hit := (*hashHit)(bucket.search(newEntry))
+		if hit2 := hit.searchValue(newEntry, v); hit2.element == nil {
+			if hit2.left.addBefore(newEntry, alloc, hit2.right) {
+				self.hash().addSize(table, 1)
+				return true
+			}
+		} else {
+			return false
+		}
+	}
+}
+
+/*
+ RemoveValue will remove v from under k and return whether it was present before.
+*/
+func (self *MultiHash) RemoveValue(k Hashable, v Comparable) bool {
+	testEntry := newKeyEntryWithHashCode(k, k.HashCode())
+	table := self.hash().getTable()
+	for {
+		bucket := table.getBucketByHashCode(testEntry.hashCode)
+		hit := (*hashHit)(bucket.search(testEntry))
+		if hit2 := hit.searchValue(testEntry, v); hit2.element != nil {
+			if hit2.element.doRemove() {
+				hit2.left.next()
+				self.hash().addSize(table, -1)
+				return true
+			}
+		} else {
+			return false
+		}
+	}
+}
+
+/*
+ each will run i on each value under k, in order.
+*/
+func (self *MultiHash) each(k Hashable, i ListIterator) bool {
+	testEntry := newKeyEntryWithHashCode(k, k.HashCode())
+	bucket := self.hash().getTable().getBucketByHashCode(testEntry.hashCode)
+	current := bucket.search(testEntry).element
+	for current != nil {
+		e := current.value.(*entry)
+		if e.hashKey != testEntry.hashKey {
+			break
+		}
+		if k.Equals(e.key) {
+			if i(e.val()) {
+				return true
+			}
+		}
+		current = current.next()
+	}
+	return false
+}
+
+/*
+ GetAll returns all values under k, ordered by their Compare method.
+*/
+func (self *MultiHash) GetAll(k Hashable) []Thing {
+	rval := make([]Thing, 0)
+	self.each(k, func(t Thing) bool {
+		rval = append(rval, t)
+		return false
+	})
+	return rval
+}
+
+/*
+ Count returns the number of values under k.
+*/
+func (self *MultiHash) Count(k Hashable) (rval int) {
+	self.each(k, func(t Thing) bool {
+		rval++
+		return false
+	})
+	return
+}
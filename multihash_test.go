@@ -0,0 +1,114 @@
+package gotomic
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+type collidingKey string
+
+func (self collidingKey) HashCode() uint32 {
+	return 7
+}
+func (self collidingKey) Equals(t Thing) bool {
+	if ck, ok := t.(collidingKey); ok {
+		return self == ck
+	}
+	return false
+}
+
+func assertMultiMappy(t *testing.T, h *MultiHash, cmp map[Hashable][]Thing) {
+	if e := h.Verify(); e != nil {
+		t.Errorf("%v should be valid, got %v", h, e)
+	}
+	size := 0
+	for k, vals := range cmp {
+		size += len(vals)
+		if all := h.GetAll(k); !reflect.DeepEqual(all, vals) {
+			t.Errorf("%v.GetAll(%v) should produce %v but produced %v", h, k, vals, all)
+		}
+		if count := h.Count(k); count != len(vals) {
+			t.Errorf("%v.Count(%v) should produce %v but produced %v", h, k, len(vals), count)
+		}
+	}
+	if h.Size() != size {
+		t.Errorf("%v should have size %v, but had size %v", h, size, h.Size())
+	}
+}
+
+func fiddleMultiHash(t *testing.T, h *MultiHash, x string, do, done chan bool) {
+	<-do
+	n := 1000
+	k := StringKey(x)
+	for i := 0; i < n; i++ {
+		if !h.Add(k, c(i)) {
+			t.Errorf("%v should not contain %v => %v", h, k, i)
+		}
+		h.Add(StringKey("shared"), c(i))
+	}
+	if count := h.Count(k); count != n {
+		t.Errorf("%v should have %v values under %v, but had %v", h, n, k, count)
+	}
+	for i := 0; i < n; i++ {
+		if !h.RemoveValue(k, c(i)) {
+			t.Errorf("%v should contain %v => %v", h, k, i)
+		}
+	}
+	done <- true
+}
+
+func TestMultiHashAddRemove(t *testing.T) {
+	h := NewMultiHash()
+	assertMultiMappy(t, h, map[Hashable][]Thing{})
+	h.Add(StringKey("a"), c(3))
+	h.Add(StringKey("a"), c(1))
+	h.Add(StringKey("a"), c(2))
+	if h.Add(StringKey("a"), c(2)) {
+		t.Error(h, "should already contain 'a' => 2")
+	}
+	h.Add(StringKey("b"), c(1))
+	assertMultiMappy(t, h, map[Hashable][]Thing{StringKey("a"): {c(1), c(2), c(3)}, StringKey("b"): {c(1)}})
+	if h.RemoveValue(StringKey("b"), c(2)) {
+		t.Error(h, "should not contain 'b' => 2")
+	}
+	if !h.RemoveValue(StringKey("a"), c(2)) {
+		t.Error(h, "should contain 'a' => 2")
+	}
+	assertMultiMappy(t, h, map[Hashable][]Thing{StringKey("a"): {c(1), c(3)}, StringKey("b"): {c(1)}})
+	h.RemoveValue(StringKey("a"), c(1))
+	h.RemoveValue(StringKey("a"), c(3))
+	assertMultiMappy(t, h, map[Hashable][]Thing{StringKey("a"): {}, StringKey("b"): {c(1)}})
+}
+
+func TestMultiHashCollisions(t *testing.T) {
+	h := NewMultiHash()
+	h.Add(collidingKey("a"), c(2))
+	h.Add(collidingKey("b"), c(2))
+	h.Add(collidingKey("a"), c(1))
+	h.Add(collidingKey("b"), c(3))
+	h.Add(collidingKey("b"), c(1))
+	assertMultiMappy(t, h, map[Hashable][]Thing{collidingKey("a"): {c(1), c(2)}, collidingKey("b"): {c(1), c(2), c(3)}})
+	h.RemoveValue(collidingKey("b"), c(2))
+	assertMultiMappy(t, h, map[Hashable][]Thing{collidingKey("a"): {c(1), c(2)}, collidingKey("b"): {c(1), c(3)}})
+}
+
+func TestMultiHashConcurrency(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	h := NewMultiHash()
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleMultiHash(t, h, fmt.Sprint("fiddler-", i), do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	shared := make([]Thing, 1000)
+	for i := range shared {
+		shared[i] = c(i)
+	}
+	assertMultiMappy(t, h, map[Hashable][]Thing{StringKey("shared"): shared})
+}
@@ -0,0 +1,188 @@
+package gotomic
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+ How many deleted nodes DeleteMin may walk past before it tries to unlink them from the head.
+*/
+const priority_queue_max_offset = 32
+
+type pqNode struct {
+	priority Comparable
+	/*
+	 Will be &deletedElement if this node is a marker.
+	*/
+	value   Thing
+	deleted int32
+	/*
+	 The next node at each level. If the next node at the bottom level is a marker it means the node after
+	 the marker, not THIS node, is deleted.
+	*/
+	next []unsafe.Pointer
+}
+
+func newPQMarker(deleted *pqNode) *pqNode {
+	return &pqNode{nil, &deletedElement, 0, []unsafe.Pointer{unsafe.Pointer(deleted)}}
+}
+func (self *pqNode) isMarker() bool {
+	return self.value == &deletedElement
+}
+func (self *pqNode) isDeleted() bool {
+	return atomic.LoadInt32(&self.deleted) == 1
+}
+
+/*
+ PriorityQueue is a concurrent min priority queue based on "A Skiplist-Based Concurrent Priority Queue with Minimal Memory Contention"
+ by Jonatan Lindén and Bengt Jonsson.
+
+ TL;DR: Nodes are kept in a skip list sorted by priority. DeleteMin deletes the first live node by marking the pointer to it
+ (using a marker node, like List does), which means that the deleted nodes always form a prefix of the bottom level that
+ Insert can't add anything inside, making DeleteMin linearizable against concurrent Inserts. The deleted prefix is unlinked in batches.
+
+ Elements with equal priority are deleted in the order they were inserted.
+
+ It is thread safe and non-blocking, and supports priorities implementing Comparable.
+*/
+type PriorityQueue struct {
+	size int64
+	head *pqNode
+}
+
+func NewPriorityQueue() *PriorityQueue {
+	return &PriorityQueue{0, &pqNode{nil, nil, 0, make([]unsafe.Pointer, skiplist_max_level)}}
+}
+func (self *PriorityQueue) Size() int {
+	return int(atomic.LoadInt64(&self.size))
+}
+func (self *PriorityQueue) String() string {
+	return fmt.Sprintf("&PriorityQueue{%p size:%v}", self, self.Size())
+}
+
+/*
+ search fills preds and succs with the last node at each level that is either deleted or not after p, and the node after it.
+*/
+func (self *PriorityQueue) search(p Comparable, preds, succs *[skiplist_max_level]*pqNode) {
+	pred := self.head
+	for level := skiplist_max_level - 1; level > 0; level-- {
+		succ := (*pqNode)(atomic.LoadPointer(&pred.next[level]))
+		for succ != nil && (succ.isDeleted() || p.Compare(succ.priority) >= 0) {
+			pred = succ
+			succ = (*pqNode)(atomic.LoadPointer(&pred.next[level]))
+		}
+		preds[level] = pred
+		succs[level] = succ
+	}
+	preds[0] = pred
+}
+
+/*
+ Insert adds v to the PriorityQueue with priority p.
+*/
+func (self *PriorityQueue) Insert(p Comparable, v Thing) {
+	var preds, succs [skiplist_max_level]*pqNode
+	newNode := &pqNode{p, v, 0, make([]unsafe.Pointer, randomSkipListLevel())}
+	self.search(p, &preds, &succs)
+	/*
+	 Walk the bottom level past all deleted nodes and all nodes not after p, and add the new node there.
+	*/
+	pred := preds[0]
+	for {
+		next := atomic.LoadPointer(&pred.next[0])
+		nextNode := (*pqNode)(next)
+		if nextNode != nil && nextNode.isMarker() {
+			pred = (*pqNode)(nextNode.next[0])
+		} else if nextNode != nil && p.Compare(nextNode.priority) >= 0 {
+			pred = nextNode
+		} else {
+			atomic.StorePointer(&newNode.next[0], next)
+			if atomic.CompareAndSwapPointer(&pred.next[0], next, unsafe.Pointer(newNode)) {
+				break
+			}
+		}
+	}
+	atomic.AddInt64(&self.size, 1)
+	/*
+	 The node is in the queue now, the upper levels are just shortcuts.
+	*/
+	for level := 1; level < len(newNode.next); level++ {
+		for {
+			if newNode.isDeleted() {
+				return
+			}
+			atomic.StorePointer(&newNode.next[level], unsafe.Pointer(succs[level]))
+			if atomic.CompareAndSwapPointer(&preds[level].next[level], unsafe.Pointer(succs[level]), unsafe.Pointer(newNode)) {
+				break
+			}
+			self.search(p, &preds, &succs)
+		}
+	}
+}
+
+/*
+ PeekMin returns the priority and value with the lowest priority, and whether the PriorityQueue contained anything.
+*/
+func (self *PriorityQueue) PeekMin() (p Comparable, v Thing, ok bool) {
+	current := self.head
+	for {
+		next := (*pqNode)(atomic.LoadPointer(&current.next[0]))
+		if next == nil {
+			return
+		}
+		if !next.isMarker() {
+			return next.priority, next.value, true
+		}
+		current = (*pqNode)(next.next[0])
+	}
+}
+
+/*
+ DeleteMin removes and returns the priority and value with the lowest priority, and whether the PriorityQueue contained anything.
+*/
+func (self *PriorityQueue) DeleteMin() (p Comparable, v Thing, ok bool) {
+	firstNext := atomic.LoadPointer(&self.head.next[0])
+	current := self.head
+	next := firstNext
+	offset := 0
+	for {
+		nextNode := (*pqNode)(next)
+		if nextNode == nil {
+			return
+		}
+		if nextNode.isMarker() {
+			current = (*pqNode)(nextNode.next[0])
+			offset++
+		} else if atomic.CompareAndSwapPointer(&current.next[0], next, unsafe.Pointer(newPQMarker(nextNode))) {
+			atomic.StoreInt32(&nextNode.deleted, 1)
+			atomic.AddInt64(&self.size, -1)
+			if offset >= priority_queue_max_offset {
+				self.restructure(firstNext, current)
+			}
+			return nextNode.priority, nextNode.value, true
+		}
+		next = atomic.LoadPointer(&current.next[0])
+	}
+}
+
+/*
+ restructure unlinks the deleted nodes between the head and lastDeleted, which is kept as the first (deleted) node
+ at the bottom level, and moves the upper levels of the head past all deleted nodes.
+*/
+func (self *PriorityQueue) restructure(firstNext unsafe.Pointer, lastDeleted *pqNode) {
+	if !atomic.CompareAndSwapPointer(&self.head.next[0], firstNext, unsafe.Pointer(newPQMarker(lastDeleted))) {
+		return
+	}
+	for level := 1; level < skiplist_max_level; level++ {
+		first := (*pqNode)(atomic.LoadPointer(&self.head.next[level]))
+		live := first
+		for live != nil && live.isDeleted() {
+			live = (*pqNode)(atomic.LoadPointer(&live.next[level]))
+		}
+		if live != first {
+			atomic.CompareAndSwapPointer(&self.head.next[level], unsafe.Pointer(first), unsafe.Pointer(live))
+		}
+	}
+}
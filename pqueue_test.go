@@ -0,0 +1,142 @@
+package gotomic
+
+import (
+	"math/rand"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	q := NewPriorityQueue()
+	if p, _, ok := q.DeleteMin(); ok {
+		t.Error(q, "should be empty, but deleted", p)
+	}
+	for _, v := range []c{5, 3, 8, 1, 9, 2} {
+		q.Insert(v, int(v))
+	}
+	if p, v, ok := q.PeekMin(); !ok || p != c(1) || v != 1 {
+		t.Error(q, "should peek 1, but peeked", p, v, ok)
+	}
+	if q.Size() != 6 {
+		t.Error(q, "should have size 6, but had", q.Size())
+	}
+	for _, exp := range []c{1, 2, 3} {
+		if p, v, ok := q.DeleteMin(); !ok || p != exp || v != int(exp) {
+			t.Error(q, "should delete", exp, "but deleted", p, v, ok)
+		}
+	}
+	q.Insert(c(0), 0)
+	q.Insert(c(6), 6)
+	for _, exp := range []c{0, 5, 6, 8, 9} {
+		if p, _, ok := q.DeleteMin(); !ok || p != exp {
+			t.Error(q, "should delete", exp, "but deleted", p, ok)
+		}
+	}
+	if q.Size() != 0 {
+		t.Error(q, "should have size 0, but had", q.Size())
+	}
+	if p, _, ok := q.PeekMin(); ok {
+		t.Error(q, "should be empty, but peeked", p)
+	}
+}
+
+func TestPriorityQueueFIFO(t *testing.T) {
+	q := NewPriorityQueue()
+	for i := 0; i < 10; i++ {
+		q.Insert(c(i%2), i)
+	}
+	for _, exp := range []int{0, 2, 4, 6, 8, 1, 3, 5, 7, 9} {
+		if _, v, ok := q.DeleteMin(); !ok || v != exp {
+			t.Error(q, "should delete", exp, "but deleted", v, ok)
+		}
+	}
+}
+
+func TestPriorityQueueRestructure(t *testing.T) {
+	q := NewPriorityQueue()
+	n := priority_queue_max_offset * 10
+	for i := 0; i < n; i++ {
+		q.Insert(c(i), i)
+	}
+	for i := 0; i < n/2; i++ {
+		if _, v, ok := q.DeleteMin(); !ok || v != i {
+			t.Error(q, "should delete", i, "but deleted", v, ok)
+		}
+		q.Insert(c(n+i), n+i)
+	}
+	for i := n / 2; i < n+n/2; i++ {
+		if _, v, ok := q.DeleteMin(); !ok || v != i {
+			t.Error(q, "should delete", i, "but deleted", v, ok)
+		}
+	}
+}
+
+func fiddlePriorityQueue(t *testing.T, q *PriorityQueue, do chan bool, done chan []int) {
+	<-do
+	n := 10000
+	var deleted []int
+	for i := 0; i < n; i++ {
+		v := rand.Int()
+		q.Insert(c(v), v)
+		if _, v, ok := q.DeleteMin(); ok {
+			deleted = append(deleted, v.(int))
+		} else {
+			t.Error(q, "should delete something")
+		}
+	}
+	done <- deleted
+}
+
+func TestPriorityQueueConc(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	q := NewPriorityQueue()
+	do := make(chan bool)
+	done := make(chan []int)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddlePriorityQueue(t, q, do, done)
+	}
+	close(do)
+	seen := make(map[int]bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		for _, v := range <-done {
+			if seen[v] {
+				t.Error(q, "deleted", v, "twice")
+			}
+			seen[v] = true
+		}
+	}
+	if q.Size() != 0 {
+		t.Error(q, "should be empty, but had size", q.Size())
+	}
+}
+
+func TestPriorityQueueConcInsert(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	q := NewPriorityQueue()
+	done := make(chan bool)
+	n := 5000
+	var all []int
+	for i := 0; i < runtime.NumCPU(); i++ {
+		vals := make([]int, n)
+		for j := range vals {
+			vals[j] = rand.Int()
+		}
+		all = append(all, vals...)
+		go func(vals []int) {
+			for _, v := range vals {
+				q.Insert(c(v), v)
+			}
+			done <- true
+		}(vals)
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	sort.Ints(all)
+	for _, exp := range all {
+		if _, v, ok := q.DeleteMin(); !ok || v != exp {
+			t.Fatal(q, "should delete", exp, "but deleted", v, ok)
+		}
+	}
+}
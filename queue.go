@@ -0,0 +1,157 @@
+package gotomic
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+/*
+ Queue is a FIFO queue based on "Simple, Fast, and Practical Non-Blocking and Blocking Concurrent Queue Algorithms" by Maged M. Michael and Michael L. Scott <http://www.cs.rochester.edu/u/scott/papers/1996_PODC_queues.pdf>
+
+ It uses the same element type as List, but never marks elements as deleted. Instead the first element is always a
+ dummy, and dequeueing an element makes it the new dummy.
+
+ It is thread safe and non-blocking.
+*/
+type Queue struct {
+	size int64
+	/*
+	 Will point to the dummy element before the first element in the Queue.
+	*/
+	head unsafe.Pointer
+	/*
+	 Will point to the last element in the Queue, or to an element close before it.
+	*/
+	tail   unsafe.Pointer
+	signal signal
+}
+
+func NewQueue() *Queue {
+	dummy := unsafe.Pointer(&element{})
+	return &Queue{0, dummy, dummy, signal{}}
+}
+func (self *Queue) Size() int {
+	return int(atomic.LoadInt64(&self.size))
+}
+
+/*
+ Enqueue adds t to the end of the Queue.
+*/
+func (self *Queue) Enqueue(t Thing) {
+	newElement := &element{nil, t}
+	for {
+		tail := atomic.LoadPointer(&self.tail)
+		tailElement := (*element)(tail)
+		next := atomic.LoadPointer(&tailElement.Pointer)
+		if tail != atomic.LoadPointer(&self.tail) {
+			continue
+		}
+		if next == nil {
+			if atomic.CompareAndSwapPointer(&tailElement.Pointer, nil, unsafe.Pointer(newElement)) {
+				atomic.CompareAndSwapPointer(&self.tail, tail, unsafe.Pointer(newElement))
+				break
+			}
+		} else {
+			/*
+			 Someone else has added an element without being able to move the tail yet, help them.
+			*/
+			atomic.CompareAndSwapPointer(&self.tail, tail, next)
+		}
+	}
+	atomic.AddInt64(&self.size, 1)
+	self.signal.notify()
+}
+
+/*
+ Dequeue removes and returns the first element of the Queue.
+*/
+func (self *Queue) Dequeue() (rval Thing, ok bool) {
+	for {
+		head := atomic.LoadPointer(&self.head)
+		tail := atomic.LoadPointer(&self.tail)
+		next := atomic.LoadPointer(&(*element)(head).Pointer)
+		if head != atomic.LoadPointer(&self.head) {
+			continue
+		}
+		if head == tail {
+			if next == nil {
+				return nil, false
+			}
+			atomic.CompareAndSwapPointer(&self.tail, tail, next)
+		} else {
+			rval = (*element)(next).value
+			if atomic.CompareAndSwapPointer(&self.head, head, next) {
+				atomic.AddInt64(&self.size, -1)
+				return rval, true
+			}
+		}
+	}
+}
+
+/*
+ DequeueWait removes and returns the first element of the Queue, waiting for something to be enqueued if it is empty, until ctx is done.
+*/
+func (self *Queue) DequeueWait(ctx context.Context) (rval Thing, err error) {
+	err = self.signal.wait(ctx, func() (ok bool) {
+		rval, ok = self.Dequeue()
+		return
+	})
+	return
+}
+
+/*
+ TryDequeueTimeout removes and returns the first element of the Queue, waiting at most timeout for something to be enqueued if it is empty.
+*/
+func (self *Queue) TryDequeueTimeout(timeout time.Duration) (rval Thing, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rval, err := self.DequeueWait(ctx)
+	return rval, err == nil
+}
+
+/*
+ Peek returns the first element of the Queue without removing it.
+*/
+func (self *Queue) Peek() (rval Thing, ok bool) {
+	head := (*element)(atomic.LoadPointer(&self.head))
+	if next := (*element)(atomic.LoadPointer(&head.Pointer)); next != nil {
+		return next.value, true
+	}
+	return nil, false
+}
+
+/*
+ Each will run i on each element, from first to last.
+
+ It returns true if the iteration was interrupted.
+ This is the case when one of the ListIterator calls returned true, indicating
+ the iteration should be stopped.
+*/
+func (self *Queue) Each(i ListIterator) bool {
+	current := (*element)(atomic.LoadPointer(&(*element)(atomic.LoadPointer(&self.head)).Pointer))
+	for current != nil {
+		if i(current.value) {
+			return true
+		}
+		current = (*element)(atomic.LoadPointer(&current.Pointer))
+	}
+	return false
+}
+
+/*
+ ToSlice returns a []Thing that is logically identical to the Queue.
+*/
+func (self *Queue) ToSlice() []Thing {
+	rval := make([]Thing, 0)
+	self.Each(func(t Thing) bool {
+		rval = append(rval, t)
+		return false
+	})
+	return rval
+}
+func (self *Queue) String() string {
+	return fmt.Sprint(self.ToSlice())
+}
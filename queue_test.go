@@ -0,0 +1,104 @@
+package gotomic
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func assertQueuey(t *testing.T, q *Queue, cmp []Thing) {
+	if q.Size() != len(cmp) {
+		t.Errorf("%v should have size %v but had %v", q, len(cmp), q.Size())
+	}
+	if sl := q.ToSlice(); !reflect.DeepEqual(sl, cmp) {
+		t.Errorf("%v should be %#v but is %#v", q, cmp, sl)
+	}
+	if len(cmp) > 0 {
+		if p, ok := q.Peek(); !ok || p != cmp[0] {
+			t.Errorf("%v should peek %v but peeked %v", q, cmp[0], p)
+		}
+	} else if p, ok := q.Peek(); ok {
+		t.Errorf("%v should not peek anything but peeked %v", q, p)
+	}
+}
+
+func fiddleQueue(t *testing.T, q *Queue, x int, do chan bool, done chan []int) {
+	<-do
+	n := 10000
+	var dequeued []int
+	for i := 0; i < n; i++ {
+		q.Enqueue([]int{x, i})
+		if v, ok := q.Dequeue(); ok {
+			dequeued = append(dequeued, v.([]int)[0], v.([]int)[1])
+		} else {
+			t.Errorf("%v should dequeue something", q)
+		}
+	}
+	done <- dequeued
+}
+
+func TestQueue(t *testing.T) {
+	q := NewQueue()
+	assertQueuey(t, q, []Thing{})
+	q.Enqueue("a")
+	assertQueuey(t, q, []Thing{"a"})
+	q.Enqueue("b")
+	q.Enqueue("c")
+	assertQueuey(t, q, []Thing{"a", "b", "c"})
+	if v, ok := q.Dequeue(); !ok || v != "a" {
+		t.Errorf("%v should dequeue 'a' but dequeued %v", q, v)
+	}
+	assertQueuey(t, q, []Thing{"b", "c"})
+	q.Dequeue()
+	q.Dequeue()
+	assertQueuey(t, q, []Thing{})
+	if v, ok := q.Dequeue(); ok {
+		t.Errorf("%v should not dequeue anything but dequeued %v", q, v)
+	}
+	q.Enqueue("d")
+	assertQueuey(t, q, []Thing{"d"})
+}
+
+func TestQueueConc(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	q := NewQueue()
+	do := make(chan bool)
+	done := make(chan []int)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleQueue(t, q, i, do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		/*
+		 Each fiddler must have seen the values of each other fiddler in the order they were enqueued.
+		*/
+		last := make(map[int]int)
+		dequeued := <-done
+		for j := 0; j < len(dequeued); j += 2 {
+			if l, ok := last[dequeued[j]]; ok && l >= dequeued[j+1] {
+				t.Errorf("dequeued %v from %v after %v", dequeued[j+1], dequeued[j], l)
+			}
+			last[dequeued[j]] = dequeued[j+1]
+		}
+	}
+	assertQueuey(t, q, []Thing{})
+}
+
+func TestQueueDequeueWait(t *testing.T) {
+	q := NewQueue()
+	if v, ok := q.TryDequeueTimeout(time.Millisecond); ok {
+		t.Error(q, "should not dequeue anything, but dequeued", v)
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		q.Enqueue("a")
+		q.Enqueue("b")
+	}()
+	if v, ok := q.TryDequeueTimeout(time.Second); !ok || v != "a" {
+		t.Error(q, "should dequeue 'a', but dequeued", v, ok)
+	}
+	if v, ok := q.TryDequeueTimeout(time.Second); !ok || v != "b" {
+		t.Error(q, "should dequeue 'b', but dequeued", v, ok)
+	}
+}
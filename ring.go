@@ -0,0 +1,153 @@
+package gotomic
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+type ringSlot struct {
+	/*
+	 The position this slot is ready for. When it equals the enqueue position the slot is empty and ready to be
+	 written, and when it equals the dequeue position + 1 the slot is full and ready to be read.
+	*/
+	sequence uint64
+	value    Thing
+}
+
+/*
+ RingBuffer is a bounded FIFO queue based on "Bounded MPMC queue" by Dmitry Vyukov <http://www.1024cores.net/home/lock-free-algorithms/queues/bounded-mpmc-queue>.
+
+ TL;DR: Each slot in the ring has a sequence number telling whether it is ready to be written or read for the current
+ lap around the ring, so producers and consumers only have to compete with each other for the enqueue or dequeue position
+ using a single CAS.
+
+ It is thread safe and non-blocking, and never allocates anything after being created.
+*/
+type RingBuffer struct {
+	enqueuePos uint64
+	dequeuePos uint64
+	mask       uint64
+	slots      []ringSlot
+}
+
+/*
+ NewRingBuffer returns a RingBuffer able to contain at least capacity elements.
+
+ The capacity will be rounded up to the nearest power of two, and will be at least 2.
+*/
+func NewRingBuffer(capacity int) *RingBuffer {
+	size := uint64(2)
+	for size < uint64(capacity) {
+		size <<= 1
+	}
+	rval := &RingBuffer{0, 0, size - 1, make([]ringSlot, size)}
+	for index := range rval.slots {
+		rval.slots[index].sequence = uint64(index)
+	}
+	return rval
+}
+
+/*
+ Cap returns the number of elements the RingBuffer can contain.
+*/
+func (self *RingBuffer) Cap() int {
+	return len(self.slots)
+}
+
+/*
+ Len returns the number of elements in the RingBuffer.
+*/
+func (self *RingBuffer) Len() int {
+	dequeuePos := atomic.LoadUint64(&self.dequeuePos)
+	enqueuePos := atomic.LoadUint64(&self.enqueuePos)
+	if enqueuePos < dequeuePos {
+		return 0
+	}
+	if l := int(enqueuePos - dequeuePos); l < len(self.slots) {
+		return l
+	}
+	return len(self.slots)
+}
+
+/*
+ TryPush adds t to the end of the RingBuffer and returns true, unless the RingBuffer is full.
+*/
+func (self *RingBuffer) TryPush(t Thing) bool {
+	pos := atomic.LoadUint64(&self.enqueuePos)
+	for {
+		slot := &self.slots[pos&self.mask]
+		seq := atomic.LoadUint64(&slot.sequence)
+		if diff := int64(seq - pos); diff == 0 {
+			if atomic.CompareAndSwapUint64(&self.enqueuePos, pos, pos+1) {
+				slot.value = t
+				atomic.StoreUint64(&slot.sequence, pos+1)
+				return true
+			}
+		} else if diff < 0 {
+			/*
+			 The slot still contains the element from the last lap, so we are full.
+			*/
+			return false
+		}
+		pos = atomic.LoadUint64(&self.enqueuePos)
+	}
+}
+
+/*
+ TryPop removes and returns the first element of the RingBuffer, unless the RingBuffer is empty.
+*/
+func (self *RingBuffer) TryPop() (rval Thing, ok bool) {
+	pos := atomic.LoadUint64(&self.dequeuePos)
+	for {
+		slot := &self.slots[pos&self.mask]
+		seq := atomic.LoadUint64(&slot.sequence)
+		if diff := int64(seq - (pos + 1)); diff == 0 {
+			if atomic.CompareAndSwapUint64(&self.dequeuePos, pos, pos+1) {
+				rval = slot.value
+				slot.value = nil
+				atomic.StoreUint64(&slot.sequence, pos+self.mask+1)
+				return rval, true
+			}
+		} else if diff < 0 {
+			/*
+			 The slot hasn't been written this lap, so we are empty.
+			*/
+			return nil, false
+		}
+		pos = atomic.LoadUint64(&self.dequeuePos)
+	}
+}
+
+/*
+ TryPushBatch adds the elements of ts to the end of the RingBuffer until it is full, and returns the number of elements it added.
+
+ The elements are added one by one, so they may be interleaved with elements pushed by other goroutines.
+*/
+func (self *RingBuffer) TryPushBatch(ts []Thing) (rval int) {
+	for _, t := range ts {
+		if !self.TryPush(t) {
+			break
+		}
+		rval++
+	}
+	return
+}
+
+/*
+ TryPopBatch removes elements from the RingBuffer into dst until it is empty or dst is full, and returns the number of elements it removed.
+*/
+func (self *RingBuffer) TryPopBatch(dst []Thing) (rval int) {
+	for rval < len(dst) {
+		t, ok := self.TryPop()
+		if !ok {
+			break
+		}
+		dst[rval] = t
+		rval++
+	}
+	return
+}
+
+func (self *RingBuffer) String() string {
+	return fmt.Sprintf("&RingBuffer{%p len:%v cap:%v}", self, self.Len(), self.Cap())
+}
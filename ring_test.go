@@ -0,0 +1,93 @@
+package gotomic
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func fiddleRingBuffer(t *testing.T, r *RingBuffer, x int, do chan bool, done chan []int) {
+	<-do
+	n := 10000
+	var popped []int
+	for i := 0; i < n; i++ {
+		for !r.TryPush([]int{x, i}) {
+			runtime.Gosched()
+		}
+		for {
+			if v, ok := r.TryPop(); ok {
+				popped = append(popped, v.([]int)[0], v.([]int)[1])
+				break
+			}
+			runtime.Gosched()
+		}
+	}
+	done <- popped
+}
+
+func TestRingBuffer(t *testing.T) {
+	r := NewRingBuffer(3)
+	if r.Cap() != 4 {
+		t.Error(r, "should have capacity 4, but had", r.Cap())
+	}
+	if v, ok := r.TryPop(); ok {
+		t.Error(r, "should be empty, but popped", v)
+	}
+	for i := 0; i < 4; i++ {
+		if !r.TryPush(i) {
+			t.Error(r, "should be able to push", i)
+		}
+	}
+	if r.TryPush(4) {
+		t.Error(r, "should be full")
+	}
+	if r.Len() != 4 {
+		t.Error(r, "should have length 4, but had", r.Len())
+	}
+	for i := 0; i < 4; i++ {
+		if v, ok := r.TryPop(); !ok || v != i {
+			t.Error(r, "should pop", i, "but popped", v, ok)
+		}
+	}
+	if r.Len() != 0 {
+		t.Error(r, "should have length 0, but had", r.Len())
+	}
+}
+
+func TestRingBufferBatch(t *testing.T) {
+	r := NewRingBuffer(4)
+	if n := r.TryPushBatch([]Thing{1, 2, 3, 4, 5, 6}); n != 4 {
+		t.Error(r, "should push 4, but pushed", n)
+	}
+	dst := make([]Thing, 3)
+	if n := r.TryPopBatch(dst); n != 3 || !reflect.DeepEqual(dst, []Thing{1, 2, 3}) {
+		t.Error(r, "should pop [1 2 3], but popped", dst[:n])
+	}
+	if n := r.TryPopBatch(dst); n != 1 || !reflect.DeepEqual(dst[:n], []Thing{4}) {
+		t.Error(r, "should pop [4], but popped", dst[:n])
+	}
+}
+
+func TestRingBufferConc(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	r := NewRingBuffer(16)
+	do := make(chan bool)
+	done := make(chan []int)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleRingBuffer(t, r, i, do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		last := make(map[int]int)
+		popped := <-done
+		for j := 0; j < len(popped); j += 2 {
+			if l, ok := last[popped[j]]; ok && l >= popped[j+1] {
+				t.Errorf("popped %v from %v after %v", popped[j+1], popped[j], l)
+			}
+			last[popped[j]] = popped[j+1]
+		}
+	}
+	if r.Len() != 0 {
+		t.Error(r, "should be empty, but had length", r.Len())
+	}
+}
@@ -0,0 +1,142 @@
+package gotomic
+
+import (
+	"fmt"
+)
+
+type SetIterator func(k Hashable) bool
+
+/*
+ Set is a hash set using the same split-ordered list as Hash, but without storing a value for each key.
+
+ It is thread safe and non-blocking.
+*/
+type Set Hash
+
+func NewSet() *Set {
+	return (*Set)(NewHash())
+}
+
+/*
+ NewSetFromSlice returns a Set containing all keys in s.
+*/
+func NewSetFromSlice(s []Hashable) *Set {
+	rval := NewSet()
+	for _, k := range s {
+		rval.Add(k)
+	}
+	return rval
+}
+func (self *Set) hash() *Hash {
+	return (*Hash)(self)
+}
+func (self *Set) Size() int {
+	return self.hash().Size()
+}
+
+/*
+ Clear removes all keys from the Set, like Hash#Clear.
+*/
+func (self *Set) Clear() {
+	self.hash().Clear()
+}
+
+/*
+ Add will insert k into the Set and return whether it was missing before.
+*/
+func (self *Set) Add(k Hashable) bool {
+	return self.hash().putIfMissing(newKeyEntryWithHashCode(k, k.HashCode()))
+}
+
+/*
+ Remove will remove k from the Set and return whether it was present before.
+*/
+func (self *Set) Remove(k Hashable) bool {
+	_, ok := self.hash().Delete(k)
+	return ok
+}
+
+/*
+ Contains returns whether k is present in the Set.
+*/
+func (self *Set) Contains(k Hashable) bool {
+	_, ok := self.hash().Get(k)
+	return ok
+}
+
+/*
+ Each will run i on each key.
+
+ It returns true if the iteration was interrupted.
+ This is the case when one of the SetIterator calls returned true, indicating
+ the iteration should be stopped.
+*/
+func (self *Set) Each(i SetIterator) bool {
+	return self.hash().Each(func(k Hashable, v Thing) bool {
+		return i(k)
+	})
+}
+
+/*
+ Verify the integrity of the Set.
+*/
+func (self *Set) Verify() error {
+	return self.hash().Verify()
+}
+
+/*
+ ToSlice returns a []Hashable containing the keys of the Set.
+*/
+func (self *Set) ToSlice() []Hashable {
+	rval := make([]Hashable, 0)
+	self.Each(func(k Hashable) bool {
+		rval = append(rval, k)
+		return false
+	})
+	return rval
+}
+func (self *Set) String() string {
+	return fmt.Sprint(self.ToSlice())
+}
+
+/*
+ Union returns a new Set containing the keys present in either self or other.
+*/
+func (self *Set) Union(other *Set) *Set {
+	rval := NewSet()
+	add := func(k Hashable) bool {
+		rval.Add(k)
+		return false
+	}
+	self.Each(add)
+	other.Each(add)
+	return rval
+}
+
+/*
+ Intersect returns a new Set containing the keys present in both self and other.
+*/
+func (self *Set) Intersect(other *Set) *Set {
+	rval := NewSet()
+	self.Each(func(k Hashable) bool {
+		if other.Contains(k) {
+			rval.Add(k)
+		}
+		return false
+	})
+	return rval
+}
+
+/*
+ Difference returns a new Set containing the keys present in self but not in other.
+*/
+func (self *Set) Difference(other *Set) *Set {
+	rval := NewSet()
+	self.Each(func(k Hashable) bool {
+		if !other.Contains(k) {
+			rval.Add(k)
+		}
+		return false
+	})
+	return rval
+}
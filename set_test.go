@@ -0,0 +1,102 @@
+package gotomic
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func assertSetty(t *testing.T, s *Set, cmp []Hashable) {
+	if e := s.Verify(); e != nil {
+		t.Errorf("%v should be valid, got %v", s, e)
+	}
+	if s.Size() != len(cmp) {
+		t.Errorf("%v should have size %v, but had size %v", s, len(cmp), s.Size())
+	}
+	m := make(map[Hashable]bool)
+	for _, k := range cmp {
+		m[k] = true
+		if !s.Contains(k) {
+			t.Errorf("%v should contain %v", s, k)
+		}
+	}
+	found := make(map[Hashable]bool)
+	for _, k := range s.ToSlice() {
+		found[k] = true
+	}
+	if !reflect.DeepEqual(m, found) {
+		t.Errorf("%v should be %v but is %v", s, m, found)
+	}
+}
+
+func fiddleSet(t *testing.T, set *Set, x string, do, done chan bool) {
+	<-do
+	n := 10000
+	for i := 0; i < n; i++ {
+		k := StringKey(fmt.Sprint(x, i))
+		if !set.Add(k) {
+			t.Errorf("%v should not contain %v", set, k)
+		}
+		if set.Add(k) {
+			t.Errorf("%v should already contain %v", set, k)
+		}
+	}
+	for i := 0; i < n; i++ {
+		k := StringKey(fmt.Sprint(x, i))
+		if !set.Remove(k) {
+			t.Errorf("%v should contain %v", set, k)
+		}
+		if set.Contains(k) {
+			t.Errorf("%v should not contain %v", set, k)
+		}
+	}
+	done <- true
+}
+
+func TestSetAddRemove(t *testing.T) {
+	set := NewSet()
+	assertSetty(t, set, []Hashable{})
+	if !set.Add(StringKey("a")) {
+		t.Error(set, "should not contain 'a'")
+	}
+	assertSetty(t, set, []Hashable{StringKey("a")})
+	if set.Add(StringKey("a")) {
+		t.Error(set, "should contain 'a'")
+	}
+	assertSetty(t, set, []Hashable{StringKey("a")})
+	set.Add(IntKey(1))
+	assertSetty(t, set, []Hashable{StringKey("a"), IntKey(1)})
+	if set.Remove(StringKey("b")) {
+		t.Error(set, "should not contain 'b'")
+	}
+	if !set.Remove(StringKey("a")) {
+		t.Error(set, "should contain 'a'")
+	}
+	assertSetty(t, set, []Hashable{IntKey(1)})
+}
+
+func TestSetConcurrency(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	set := NewSetFromSlice([]Hashable{IntKey(1), IntKey(2), IntKey(3)})
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleSet(t, set, fmt.Sprint("fiddler-", i, "-"), do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	assertSetty(t, set, []Hashable{IntKey(1), IntKey(2), IntKey(3)})
+}
+
+func TestSetOperations(t *testing.T) {
+	a := NewSetFromSlice([]Hashable{IntKey(1), IntKey(2), IntKey(3)})
+	b := NewSetFromSlice([]Hashable{IntKey(2), IntKey(3), IntKey(4)})
+	assertSetty(t, a.Union(b), []Hashable{IntKey(1), IntKey(2), IntKey(3), IntKey(4)})
+	assertSetty(t, a.Intersect(b), []Hashable{IntKey(2), IntKey(3)})
+	assertSetty(t, a.Difference(b), []Hashable{IntKey(1)})
+	assertSetty(t, b.Difference(a), []Hashable{IntKey(4)})
+	assertSetty(t, a, []Hashable{IntKey(1), IntKey(2), IntKey(3)})
+}
@@ -0,0 +1,70 @@
+package gotomic
+
+import (
+	"context"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+ signal lets goroutines wait for something to happen, without making the goroutines that make it happen take any locks.
+
+ The ones making it happen only pay for an atomic load as long as nobody is waiting.
+*/
+type signal struct {
+	waiters int32
+	/*
+	 Will point to a chan struct{} that will be closed by the next notify, or be nil.
+	*/
+	channel unsafe.Pointer
+}
+
+func (self *signal) getChannel() chan struct{} {
+	for {
+		if current := atomic.LoadPointer(&self.channel); current != nil {
+			return *(*chan struct{})(current)
+		}
+		c := make(chan struct{})
+		if atomic.CompareAndSwapPointer(&self.channel, nil, unsafe.Pointer(&c)) {
+			return c
+		}
+	}
+}
+
+/*
+ notify wakes up all goroutines currently waiting.
+*/
+func (self *signal) notify() {
+	if atomic.LoadInt32(&self.waiters) > 0 {
+		if current := atomic.SwapPointer(&self.channel, nil); current != nil {
+			close(*(*chan struct{})(current))
+		}
+	}
+}
+
+/*
+ wait will run try until it returns true, waiting for a notify between each attempt, or until ctx is done.
+
+ Since we register as waiting and fetch the channel before the last attempt, a notify happening after
+ that attempt will always close the channel we wait for.
+*/
+func (self *signal) wait(ctx context.Context, try func() bool) error {
+	for {
+		if try() {
+			return nil
+		}
+		atomic.AddInt32(&self.waiters, 1)
+		c := self.getChannel()
+		if try() {
+			atomic.AddInt32(&self.waiters, -1)
+			return nil
+		}
+		select {
+		case <-c:
+			atomic.AddInt32(&self.waiters, -1)
+		case <-ctx.Done():
+			atomic.AddInt32(&self.waiters, -1)
+			return ctx.Err()
+		}
+	}
+}
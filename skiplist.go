@@ -0,0 +1,409 @@
+package gotomic
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const skiplist_max_level = 32
+
+var skipListSeed uint64
+
+func init() {
+	skipListSeed = uint64(time.Now().UnixNano())
+}
+
+/*
+ randomSkipListLevel returns a level between 1 and skiplist_max_level, where each level is half as likely as the one below.
+
+ It uses splitmix64 on an atomically incremented seed, to avoid the lock in math/rand.
+*/
+func randomSkipListLevel() int {
+	z := atomic.AddUint64(&skipListSeed, 0x9e3779b97f4a7c15)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z = z ^ (z >> 31)
+	level := 1
+	for z&1 == 1 && level < skiplist_max_level {
+		level++
+		z >>= 1
+	}
+	return level
+}
+
+type SkipListIterator func(k Comparable, v Thing) bool
+
+type skipNode struct {
+	key Comparable
+	/*
+	 Will point to a Thing, or to deletedElement if this node is a marker.
+	*/
+	value unsafe.Pointer
+	/*
+	 The next node at each level. If a next node is a marker it means THIS node, not the next one, is deleted at that level.
+	*/
+	next []unsafe.Pointer
+}
+
+func newSkipMarker(succ *skipNode) *skipNode {
+	return &skipNode{nil, unsafe.Pointer(&deletedElement), []unsafe.Pointer{unsafe.Pointer(succ)}}
+}
+func (self *skipNode) isMarker() bool {
+	return self.value == unsafe.Pointer(&deletedElement)
+}
+func (self *skipNode) val() Thing {
+	return *(*Thing)(atomic.LoadPointer(&self.value))
+}
+
+/*
+ successor returns the next node at level, and whether this node is deleted at that level.
+*/
+func (self *skipNode) successor(level int) (succ *skipNode, marked bool) {
+	next := (*skipNode)(atomic.LoadPointer(&self.next[level]))
+	if next != nil && next.isMarker() {
+		return (*skipNode)(atomic.LoadPointer(&next.next[0])), true
+	}
+	return next, false
+}
+
+/*
+ nextUnmarked returns the first node after this one at level that isn't deleted at that level.
+*/
+func (self *skipNode) nextUnmarked(level int) *skipNode {
+	current, _ := self.successor(level)
+	for current != nil {
+		succ, marked := current.successor(level)
+		if !marked {
+			return current
+		}
+		current = succ
+	}
+	return nil
+}
+
+/*
+ replace will swap the value of this node for value, and return the old value and whether the node wasn't deleted
+ when the swap happened. If it was, whoever deleted it may have read either value, so it has to be put again.
+*/
+func (self *skipNode) replace(value unsafe.Pointer) (old Thing, ok bool) {
+	oldValue := atomic.SwapPointer(&self.value, value)
+	if _, marked := self.successor(0); marked {
+		return nil, false
+	}
+	return *(*Thing)(oldValue), true
+}
+
+/*
+ mark will mark this node as deleted at level, and return whether we were the ones to do it.
+*/
+func (self *skipNode) mark(level int) bool {
+	for {
+		succ, marked := self.successor(level)
+		if marked {
+			return false
+		}
+		if atomic.CompareAndSwapPointer(&self.next[level], unsafe.Pointer(succ), unsafe.Pointer(newSkipMarker(succ))) {
+			return true
+		}
+	}
+}
+
+/*
+ SkipList is an ordered map based on the lock-free skip list in "The Art of Multiprocessor Programming" by Maurice Herlihy and Nir Shavit,
+ which in turn is based on "Practical lock-freedom" by Keir Fraser <http://www.cl.cam.ac.uk/techreports/UCAM-CL-TR-579.pdf>.
+
+ Instead of stealing a bit from the next pointers to mark deleted nodes, it uses the same technique as List: a deleted node gets
+ a marker node inserted as its successor, one for each level it is deleted at. A node is logically deleted when it is marked at the bottom level.
+
+ It is thread safe and non-blocking, and supports keys implementing Comparable.
+*/
+type SkipList struct {
+	size int64
+	head *skipNode
+}
+
+func NewSkipList() *SkipList {
+	return &SkipList{0, &skipNode{nil, nil, make([]unsafe.Pointer, skiplist_max_level)}}
+}
+func (self *SkipList) Size() int {
+	return int(atomic.LoadInt64(&self.size))
+}
+
+/*
+ find will fill preds and succs with the last node before k and the first node not before k at each level, unlinking
+ all deleted nodes it finds on the way, and return whether the first node not before k at the bottom level is k.
+*/
+func (self *SkipList) find(k Comparable, preds, succs *[skiplist_max_level]*skipNode) bool {
+retry:
+	for {
+		pred := self.head
+		for level := skiplist_max_level - 1; level >= 0; level-- {
+			current, _ := pred.successor(level)
+			for current != nil {
+				succ, marked := current.successor(level)
+				for marked {
+					if !atomic.CompareAndSwapPointer(&pred.next[level], unsafe.Pointer(current), unsafe.Pointer(succ)) {
+						continue retry
+					}
+					current = succ
+					if current == nil {
+						break
+					}
+					succ, marked = current.successor(level)
+				}
+				if current == nil || k.Compare(current.key) <= 0 {
+					break
+				}
+				pred = current
+				current = succ
+			}
+			preds[level] = pred
+			succs[level] = current
+		}
+		return succs[0] != nil && k.Compare(succs[0].key) == 0
+	}
+}
+
+/*
+ seek returns the last node before is true for (or the head) and the node after it at the bottom level,
+ skipping deleted nodes without unlinking them.
+*/
+func (self *SkipList) seek(before func(n *skipNode) bool) (pred, current *skipNode) {
+	pred = self.head
+	for level := skiplist_max_level - 1; level >= 0; level-- {
+		current = pred.nextUnmarked(level)
+		for current != nil && before(current) {
+			pred = current
+			current = pred.nextUnmarked(level)
+		}
+	}
+	return
+}
+
+/*
+ Put will put k and v in the SkipList and return the overwritten value and whether any value was overwritten.
+*/
+func (self *SkipList) Put(k Comparable, v Thing) (old Thing, ok bool) {
+	var preds, succs [skiplist_max_level]*skipNode
+	newValue := unsafe.Pointer(&v)
+	var newNode *skipNode
+	for {
+		if self.find(k, &preds, &succs) {
+			if old, ok = succs[0].replace(newValue); ok {
+				return
+			}
+			continue
+		}
+		if newNode == nil {
+			newNode = &skipNode{k, newValue, make([]unsafe.Pointer, randomSkipListLevel())}
+		}
+		for level := range newNode.next {
+			newNode.next[level] = unsafe.Pointer(succs[level])
+		}
+		if atomic.CompareAndSwapPointer(&preds[0].next[0], unsafe.Pointer(succs[0]), unsafe.Pointer(newNode)) {
+			break
+		}
+	}
+	atomic.AddInt64(&self.size, 1)
+	/*
+	 The node is in the list now, the upper levels are just shortcuts.
+	*/
+	for level := 1; level < len(newNode.next); level++ {
+		for {
+			pred, succ := preds[level], succs[level]
+			current := atomic.LoadPointer(&newNode.next[level])
+			if (*skipNode)(current) != nil && (*skipNode)(current).isMarker() {
+				/*
+				 Someone is deleting the node already.
+				*/
+				return
+			}
+			if current == unsafe.Pointer(succ) || atomic.CompareAndSwapPointer(&newNode.next[level], current, unsafe.Pointer(succ)) {
+				if atomic.CompareAndSwapPointer(&pred.next[level], unsafe.Pointer(succ), unsafe.Pointer(newNode)) {
+					break
+				}
+			}
+			if !self.find(k, &preds, &succs) || succs[0] != newNode {
+				return
+			}
+		}
+	}
+	return
+}
+
+/*
+ Delete removes k from the SkipList and returns any value it removed.
+*/
+func (self *SkipList) Delete(k Comparable) (old Thing, ok bool) {
+	var preds, succs [skiplist_max_level]*skipNode
+	if !self.find(k, &preds, &succs) {
+		return
+	}
+	return self.remove(succs[0])
+}
+
+/*
+ remove will mark n as deleted from the top level and down, and return its value and whether we were the ones to delete it.
+*/
+func (self *SkipList) remove(n *skipNode) (old Thing, ok bool) {
+	for level := len(n.next) - 1; level > 0; level-- {
+		n.mark(level)
+	}
+	if n.mark(0) {
+		atomic.AddInt64(&self.size, -1)
+		var preds, succs [skiplist_max_level]*skipNode
+		self.find(n.key, &preds, &succs)
+		return n.val(), true
+	}
+	return
+}
+
+/*
+ Get returns the value at k and whether it was present in the SkipList.
+*/
+func (self *SkipList) Get(k Comparable) (v Thing, ok bool) {
+	_, current := self.seek(func(n *skipNode) bool {
+		return k.Compare(n.key) > 0
+	})
+	if current != nil && k.Compare(current.key) == 0 {
+		return current.val(), true
+	}
+	return
+}
+
+/*
+ Min returns the smallest key and its value, and whether the SkipList contained anything.
+*/
+func (self *SkipList) Min() (k Comparable, v Thing, ok bool) {
+	if current := self.head.nextUnmarked(0); current != nil {
+		return current.key, current.val(), true
+	}
+	return
+}
+
+/*
+ Max returns the largest key and its value, and whether the SkipList contained anything.
+*/
+func (self *SkipList) Max() (k Comparable, v Thing, ok bool) {
+	pred, _ := self.seek(func(n *skipNode) bool {
+		return true
+	})
+	if pred != self.head {
+		return pred.key, pred.val(), true
+	}
+	return
+}
+
+/*
+ Next returns the first key after k and its value, and whether there was any.
+*/
+func (self *SkipList) Next(k Comparable) (key Comparable, value Thing, ok bool) {
+	_, current := self.seek(func(n *skipNode) bool {
+		return k.Compare(n.key) >= 0
+	})
+	if current != nil {
+		return current.key, current.val(), true
+	}
+	return
+}
+
+/*
+ Previous returns the last key before k and its value, and whether there was any.
+*/
+func (self *SkipList) Previous(k Comparable) (key Comparable, value Thing, ok bool) {
+	pred, _ := self.seek(func(n *skipNode) bool {
+		return k.Compare(n.key) > 0
+	})
+	if pred != self.head {
+		return pred.key, pred.val(), true
+	}
+	return
+}
+
+/*
+ Each will run i on each key and value, in order.
+
+ It returns true if the iteration was interrupted.
+ This is the case when one of the SkipListIterator calls returned true, indicating
+ the iteration should be stopped.
+*/
+func (self *SkipList) Each(i SkipListIterator) bool {
+	return self.Range(nil, nil, i)
+}
+
+/*
+ Range will run i on each key and value from (and including) from to (but not including) to, in order.
+
+ A nil from or to means the range is unbounded in that direction.
+
+ It returns true if the iteration was interrupted.
+ This is the case when one of the SkipListIterator calls returned true, indicating
+ the iteration should be stopped.
+*/
+func (self *SkipList) Range(from, to Comparable, i SkipListIterator) bool {
+	var current *skipNode
+	if from == nil {
+		current = self.head.nextUnmarked(0)
+	} else {
+		_, current = self.seek(func(n *skipNode) bool {
+			return from.Compare(n.key) > 0
+		})
+	}
+	for current != nil && (to == nil || to.Compare(current.key) > 0) {
+		if i(current.key, current.val()) {
+			return true
+		}
+		current = current.nextUnmarked(0)
+	}
+	return false
+}
+
+/*
+ ToSlice returns the keys and values of the SkipList, in order.
+*/
+func (self *SkipList) ToSlice() (keys []Comparable, values []Thing) {
+	self.Each(func(k Comparable, v Thing) bool {
+		keys = append(keys, k)
+		values = append(values, v)
+		return false
+	})
+	return
+}
+func (self *SkipList) String() string {
+	buf := new(bytes.Buffer)
+	fmt.Fprint(buf, "[")
+	self.Each(func(k Comparable, v Thing) bool {
+		if buf.Len() > 1 {
+			fmt.Fprint(buf, " ")
+		}
+		fmt.Fprintf(buf, "%v:%v", k, v)
+		return false
+	})
+	fmt.Fprint(buf, "]")
+	return string(buf.Bytes())
+}
+
+/*
+ Verify that all levels of the SkipList are ordered, and that all nodes in the upper levels are in the bottom level.
+*/
+func (self *SkipList) Verify() error {
+	bottom := make(map[*skipNode]bool)
+	for level := 0; level < skiplist_max_level; level++ {
+		var last *skipNode
+		for current := self.head.nextUnmarked(level); current != nil; current = current.nextUnmarked(level) {
+			if last != nil && last.key.Compare(current.key) >= 0 {
+				return fmt.Errorf("%v has %v before %v at level %v", self, last.key, current.key, level)
+			}
+			if level == 0 {
+				bottom[current] = true
+			} else if _, marked := current.successor(0); !marked && !bottom[current] {
+				return fmt.Errorf("%v has %v at level %v but not at level 0", self, current.key, level)
+			}
+			last = current
+		}
+	}
+	return nil
+}
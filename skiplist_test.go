@@ -0,0 +1,226 @@
+package gotomic
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func fiddleSkipList(t *testing.T, l *SkipList, x string, do, done chan bool) {
+	<-do
+	n := int(10000 + rand.Int31()%1000)
+	vals := make([]s, n)
+	for i := 0; i < n; i++ {
+		v := s(fmt.Sprint(rand.Int63(), ".", i, ".", x))
+		vals[i] = v
+		if _, ok := l.Put(v, v); ok {
+			t.Errorf("%v should not contain %v", l, v)
+		}
+		if value, ok := l.Get(v); !ok || v.Compare(value) != 0 {
+			t.Errorf("%v should contain %v", l, v)
+		}
+	}
+	for i := 0; i < n; i++ {
+		v := vals[i]
+		if old, ok := l.Delete(v); !ok || old != v {
+			t.Errorf("%v should contain %v", l, v)
+		}
+		if _, ok := l.Get(v); ok {
+			t.Errorf("%v should not contain %v", l, v)
+		}
+	}
+	done <- true
+}
+
+func TestSkipListConc(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	l := NewSkipList()
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleSkipList(t, l, fmt.Sprint(i), do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	if l.Size() != 0 {
+		t.Error(l, "should be empty, but had size", l.Size())
+	}
+	if err := l.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func assertSkipListSlice(t *testing.T, l *SkipList, keys []Comparable, values []Thing) {
+	found_keys, found_values := l.ToSlice()
+	if !reflect.DeepEqual(keys, found_keys) {
+		t.Errorf("%v should have keys %v but had %v", l, keys, found_keys)
+	}
+	if !reflect.DeepEqual(values, found_values) {
+		t.Errorf("%v should have values %v but had %v", l, values, found_values)
+	}
+	if l.Size() != len(keys) {
+		t.Errorf("%v should have size %v but had %v", l, len(keys), l.Size())
+	}
+	if err := l.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSkipListPutGetDelete(t *testing.T) {
+	l := NewSkipList()
+	assertSkipListSlice(t, l, nil, nil)
+	if _, ok := l.Put(s("b"), "B"); ok {
+		t.Error(l, "should not contain b")
+	}
+	l.Put(s("c"), "C")
+	l.Put(s("a"), "A")
+	assertSkipListSlice(t, l, []Comparable{s("a"), s("b"), s("c")}, []Thing{"A", "B", "C"})
+	if old, ok := l.Put(s("b"), "BB"); !ok || old != "B" {
+		t.Error(l, "should have replaced B, but replaced", old, ok)
+	}
+	if v, ok := l.Get(s("b")); !ok || v != "BB" {
+		t.Error(l, "should contain BB, but had", v, ok)
+	}
+	if v, ok := l.Get(s("d")); ok {
+		t.Error(l, "should not contain d, but had", v)
+	}
+	if old, ok := l.Delete(s("b")); !ok || old != "BB" {
+		t.Error(l, "should have deleted BB, but deleted", old, ok)
+	}
+	if old, ok := l.Delete(s("b")); ok {
+		t.Error(l, "should not contain b, but deleted", old)
+	}
+	assertSkipListSlice(t, l, []Comparable{s("a"), s("c")}, []Thing{"A", "C"})
+}
+
+func TestSkipListPutDeleted(t *testing.T) {
+	l := NewSkipList()
+	l.Put(s("a"), "A")
+	var preds, succs [skiplist_max_level]*skipNode
+	l.find(s("a"), &preds, &succs)
+	n := succs[0]
+	/*
+	 Like a Put that found the node before a Delete removed it.
+	*/
+	if old, ok := l.remove(n); !ok || old != "A" {
+		t.Error(l, "should have removed A, but removed", old, ok)
+	}
+	v := Thing("B")
+	if old, ok := n.replace(unsafe.Pointer(&v)); ok {
+		t.Error(l, "should not replace values of deleted nodes, but replaced", old)
+	}
+	if old, ok := l.Put(s("a"), "C"); ok {
+		t.Error(l, "should not contain a, but replaced", old)
+	}
+	assertSkipListSlice(t, l, []Comparable{s("a")}, []Thing{"C"})
+}
+
+func TestSkipListMinMaxPreviousNext(t *testing.T) {
+	l := NewSkipList()
+	if k, _, ok := l.Min(); ok {
+		t.Error(l, "should not have a min, but had", k)
+	}
+	if k, _, ok := l.Max(); ok {
+		t.Error(l, "should not have a max, but had", k)
+	}
+	for _, k := range []string{"b", "d", "f"} {
+		l.Put(s(k), k)
+	}
+	if k, v, ok := l.Min(); !ok || k != s("b") || v != "b" {
+		t.Error(l, "should have min b, but had", k, v, ok)
+	}
+	if k, v, ok := l.Max(); !ok || k != s("f") || v != "f" {
+		t.Error(l, "should have max f, but had", k, v, ok)
+	}
+	if k, _, ok := l.Next(s("b")); !ok || k != s("d") {
+		t.Error(l, "should have d after b, but had", k, ok)
+	}
+	if k, _, ok := l.Next(s("c")); !ok || k != s("d") {
+		t.Error(l, "should have d after c, but had", k, ok)
+	}
+	if k, _, ok := l.Next(s("f")); ok {
+		t.Error(l, "should have nothing after f, but had", k)
+	}
+	if k, _, ok := l.Previous(s("d")); !ok || k != s("b") {
+		t.Error(l, "should have b before d, but had", k, ok)
+	}
+	if k, _, ok := l.Previous(s("g")); !ok || k != s("f") {
+		t.Error(l, "should have f before g, but had", k, ok)
+	}
+	if k, _, ok := l.Previous(s("b")); ok {
+		t.Error(l, "should have nothing before b, but had", k)
+	}
+}
+
+func TestSkipListRange(t *testing.T) {
+	l := NewSkipList()
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		l.Put(s(k), k)
+	}
+	var found []Thing
+	collect := func(k Comparable, v Thing) bool {
+		found = append(found, v)
+		return false
+	}
+	l.Range(s("b"), s("d"), collect)
+	if !reflect.DeepEqual(found, []Thing{"b", "c"}) {
+		t.Error(l, "should have b and c in [b, d), but had", found)
+	}
+	found = nil
+	l.Range(s("bb"), nil, collect)
+	if !reflect.DeepEqual(found, []Thing{"c", "d", "e"}) {
+		t.Error(l, "should have c, d and e from bb, but had", found)
+	}
+	found = nil
+	l.Range(nil, s("b"), collect)
+	if !reflect.DeepEqual(found, []Thing{"a"}) {
+		t.Error(l, "should have a before b, but had", found)
+	}
+	if !l.Each(func(k Comparable, v Thing) bool { return k == s("c") }) {
+		t.Error(l, "should have been interrupted")
+	}
+}
+
+func BenchmarkSkipList(b *testing.B) {
+	m := NewSkipList()
+	for i := 0; i < b.N; i++ {
+		k := compInt(rand.Int())
+		m.Put(k, i)
+		j, _ := m.Get(k)
+		if j != i {
+			b.Error("should be same value")
+		}
+	}
+}
+
+func skipListAction(b *testing.B, m *SkipList, i int, do, done chan bool) {
+	<-do
+	for j := 0; j < i; j++ {
+		k := compInt(rand.Int())
+		m.Put(k, rand.Int())
+		m.Get(k)
+	}
+	done <- true
+}
+
+func BenchmarkSkipListConc(b *testing.B) {
+	b.StopTimer()
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	do := make(chan bool)
+	done := make(chan bool)
+	m := NewSkipList()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go skipListAction(b, m, b.N, do, done)
+	}
+	close(do)
+	b.StartTimer()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	runtime.GOMAXPROCS(1)
+}
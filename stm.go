@@ -2,6 +2,7 @@ package gotomic
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"sort"
 	"sync/atomic"
@@ -18,6 +19,57 @@ const (
 var lastCommit uint64 = 0
 var lastBegin uint64 = 0
 
+/*
+ Isolation is the isolation level of a Transaction.
+*/
+type Isolation int
+
+const (
+	/*
+	 Serializable Transactions validate everything they have read when committing, and fail if any of it has changed.
+	 They behave as if all Transactions ran one at a time.
+	*/
+	Serializable Isolation = iota
+	/*
+	 SnapshotIsolation Transactions only fail to commit if something they have written, or read using ReadForUpdate,
+	 has changed.
+
+	 They still only see data committed before they began, but anything decided based on a plain Read may be based
+	 on data that has changed before the commit. The classic example is write skew: Two Transactions may both read
+	 x and y, and then one write x and the other y based on what they read, and both commit. If x and y had to be
+	 kept consistent with each other (say x+y >= 0) that may no longer be the case. To avoid it with SnapshotIsolation,
+	 use ReadForUpdate (or Write) for everything a decision depends on.
+
+	 The structures in this package use ReadForUpdate for the reads that decide where they write, so their own
+	 structure stays consistent with SnapshotIsolation, and no committed change is lost. Operations that only
+	 read them, like Treap.GetTx, use plain Reads.
+
+	 SnapshotIsolation Transactions that don't write or ReadForUpdate anything never fail to commit.
+	*/
+	SnapshotIsolation
+)
+
+func (self Isolation) String() string {
+	switch self {
+	case Serializable:
+		return "serializable"
+	case SnapshotIsolation:
+		return "snapshot isolation"
+	}
+	return fmt.Sprintf("Isolation(%d)", int(self))
+}
+
+/*
+ ConflictError is returned when a Transaction has seen data changed by another Transaction, and can never commit.
+*/
+type ConflictError struct {
+	message string
+}
+
+func (self ConflictError) Error() string {
+	return self.message
+}
+
 /*
  Clonable types can be handled by the transaction layer.
 */
@@ -77,6 +129,10 @@ func (self *version) clone() *version {
 type snapshot struct {
 	old *version
 	neu *version
+	/*
+	 Whether the read has to be validated when committing, even with SnapshotIsolation.
+	*/
+	validate bool
 }
 
 type write struct {
@@ -109,6 +165,8 @@ func (self writes) Less(i, j int) bool {
  2) It copies the data not only on write opening, but also on read opening.
 
  These changes will make the transactions act more along the lines of "Sandboxing Transactional Memory" by Luke Dalessandro and Michael L. Scott <http://www.cs.rochester.edu/u/scott/papers/2012_TRANSACT_sandboxing.pdf> and will hopefully avoid the need to kill transactions exhibiting invalid behaviour due to inconsistent states.
+
+ Transactions are Serializable by default, but can use SnapshotIsolation to skip validating what they have read when committing.
 */
 type Transaction struct {
 	/*
@@ -117,21 +175,113 @@ type Transaction struct {
 	beginNumber  uint64
 	commitNumber uint64
 	status       int32
+	isolation    Isolation
 	readHandles  map[*Handle]*snapshot
 	writeHandles map[*Handle]*snapshot
 	sortedWrites writes
+	/*
+	 Run once by the thread owning the Transaction when it has committed or aborted.
+	*/
+	onCommit []func()
+	onAbort  []func()
+	hooksRun int32
+	/*
+	 The Savepoints that can still be rolled back to, oldest first.
+	*/
+	savepoints []*Savepoint
+	/*
+	 Nested Transactions read and write through their parent, and roll it back to savepoint if aborted.
+	*/
+	parent    *Transaction
+	savepoint *Savepoint
+}
+
+/*
+ Savepoint is the state of the writes of a Transaction at some point, that it can be rolled back to.
+*/
+type Savepoint struct {
+	transaction *Transaction
+	/*
+	 Copies of the content of all handles written when the Savepoint was created.
+	*/
+	writes   map[*Handle]Clonable
+	onCommit int
+	onAbort  int
 }
 
 func NewTransaction() *Transaction {
+	return NewTransactionWithIsolation(Serializable)
+}
+
+/*
+ NewTransactionWithIsolation returns a Transaction with the given isolation level.
+*/
+func NewTransactionWithIsolation(isolation Isolation) *Transaction {
 	return &Transaction{
 		atomic.AddUint64(&lastBegin, 1),
 		atomic.LoadUint64(&lastCommit),
 		undecided,
+		isolation,
 		make(map[*Handle]*snapshot),
 		make(map[*Handle]*snapshot),
 		nil,
+		nil,
+		nil,
+		0,
+		nil,
+		nil,
+		nil,
 	}
 }
+
+/*
+ root returns the outermost Transaction that self is nested in, or self if it isn't nested.
+*/
+func (self *Transaction) root() *Transaction {
+	for self.parent != nil {
+		self = self.parent
+	}
+	return self
+}
+
+/*
+ Atomically runs f with a new Transaction and commits it, and keeps retrying with new Transactions as long as f
+ returns a ConflictError (or an error wrapping one) or the commit fails.
+
+ Any other error returned by f aborts the Transaction and is returned.
+
+ f may be run many times, so it should not have side effects except through the Transaction, or OnCommit and OnAbort.
+*/
+func Atomically(f func(t *Transaction) error) error {
+	return AtomicallyWithIsolation(Serializable, f)
+}
+
+/*
+ AtomicallyWithIsolation is Atomically using Transactions with the given isolation level.
+*/
+func AtomicallyWithIsolation(isolation Isolation, f func(t *Transaction) error) error {
+	for {
+		t := NewTransactionWithIsolation(isolation)
+		err := f(t)
+		if err == nil {
+			if t.Commit() {
+				return nil
+			}
+			continue
+		}
+		t.Abort()
+		if !errors.As(err, &ConflictError{}) {
+			return err
+		}
+	}
+}
+
+/*
+ Isolation returns the isolation level of the Transaction, which for nested Transactions is that of the outermost one.
+*/
+func (self *Transaction) Isolation() Isolation {
+	return self.root().isolation
+}
 func (self *Transaction) getStatus() int32 {
 	return atomic.LoadInt32(&self.status)
 }
@@ -140,7 +290,7 @@ func (self *Transaction) objRead(h *Handle) (rval *version, err error) {
 	other := version.lockedBy
 	if other != nil {
 		if other.getStatus() == read_check && self.getStatus() == read_check && self.beginNumber < other.beginNumber {
-			other.Abort()
+			other.abort()
 		} else {
 			other.commit()
 		}
@@ -150,7 +300,7 @@ func (self *Transaction) objRead(h *Handle) (rval *version, err error) {
 		}
 	}
 	if atomic.LoadUint64(&version.commitNumber) > atomic.LoadUint64(&self.commitNumber) {
-		err = fmt.Errorf("%v has changed", version.content)
+		err = ConflictError{fmt.Sprintf("%v has changed", version.content)}
 	} else {
 		rval = version
 	}
@@ -210,8 +360,15 @@ func (self *Transaction) acquire() bool {
 	}
 	return true
 }
+
+/*
+ readCheck returns whether nothing read by the Transaction has changed, which SnapshotIsolation Transactions only care about for ReadForUpdate.
+*/
 func (self *Transaction) readCheck() bool {
 	for handle, snapshot := range self.readHandles {
+		if self.isolation == SnapshotIsolation && !snapshot.validate {
+			continue
+		}
 		if handle.getVersion() != snapshot.old {
 			if self.getStatus() == successful {
 				return true
@@ -230,7 +387,7 @@ func (self *Transaction) readCheck() bool {
 */
 func (self *Transaction) commit() bool {
 	if !self.acquire() {
-		self.Abort()
+		self.abort()
 		return false
 	}
 	defer self.release()
@@ -238,7 +395,7 @@ func (self *Transaction) commit() bool {
 		atomic.StoreUint64(&self.commitNumber, atomic.AddUint64(&lastCommit, 1))
 	}
 	if !self.readCheck() {
-		self.Abort()
+		self.abort()
 		return false
 	}
 	atomic.CompareAndSwapInt32(&self.status, read_check, successful)
@@ -248,9 +405,20 @@ func (self *Transaction) commit() bool {
 /*
  Commit the transaction. Will return whether the commit was successful or not.
 
+ Runs the OnCommit or OnAbort functions, depending on the outcome, before returning.
+
+ Committing a nested Transaction only merges its writes into its parent, to be committed or aborted along with it.
+
  Safe to call multiple times, but only from one thread.
 */
 func (self *Transaction) Commit() bool {
+	if self.parent != nil {
+		if atomic.CompareAndSwapInt32(&self.status, undecided, successful) {
+			self.root().dropSavepoints(self.savepoint)
+		}
+		return self.getStatus() == successful
+	}
+	defer self.runHooks()
 	status := self.getStatus()
 	if status == undecided {
 		self.sortWrites()
@@ -268,11 +436,30 @@ func (self *Transaction) Commit() bool {
 /*
  Abort the transaction unless it is already successful.
 
+ Runs the OnAbort functions if the transaction ends up aborted, or the OnCommit functions if it was already successful.
+
  Safe to call multiple times.
 
- Unless the transaction is half-committed Abort isn't really necessary, the gc will clean it up properly.
+ Unless the transaction is half-committed or has OnAbort functions Abort isn't really necessary, the gc will clean it up properly.
+
+ Aborting a nested Transaction rolls its parent back to where the nested Transaction began, and leaves the parent undecided.
 */
 func (self *Transaction) Abort() {
+	if self.parent != nil {
+		if atomic.CompareAndSwapInt32(&self.status, undecided, failed) {
+			self.parent.RollbackTo(self.savepoint)
+			self.root().dropSavepoints(self.savepoint)
+		}
+		return
+	}
+	self.abort()
+	self.runHooks()
+}
+
+/*
+ abort is Abort without running any hooks, for other Transactions helping or hindering this one.
+*/
+func (self *Transaction) abort() {
 	stat := self.getStatus()
 	for stat != successful && stat != failed {
 		atomic.CompareAndSwapInt32(&self.status, stat, failed)
@@ -281,6 +468,59 @@ func (self *Transaction) Abort() {
 	self.release()
 }
 
+/*
+ OnCommit will run f once, in the thread calling Commit, after the Transaction has successfully committed.
+
+ For nested Transactions f is run when the outermost Transaction commits.
+
+ If the Transaction already has committed f will be run immediately.
+ Functions are run in the order they were added.
+*/
+func (self *Transaction) OnCommit(f func()) {
+	self = self.root()
+	self.onCommit = append(self.onCommit, f)
+	if atomic.LoadInt32(&self.hooksRun) == 1 && self.getStatus() == successful {
+		f()
+	}
+}
+
+/*
+ OnAbort will run f once, in the thread calling Commit or Abort, after the Transaction has failed to commit or been aborted.
+
+ f is also run if the Transaction is rolled back to a Savepoint created before f was added, or if it is a
+ nested Transaction that is aborted.
+
+ If the Transaction already has been aborted f will be run immediately.
+ Functions are run in the order they were added.
+*/
+func (self *Transaction) OnAbort(f func()) {
+	self = self.root()
+	self.onAbort = append(self.onAbort, f)
+	if atomic.LoadInt32(&self.hooksRun) == 1 && self.getStatus() == failed {
+		f()
+	}
+}
+
+/*
+ runHooks runs the OnCommit or OnAbort functions the first time it is called after the outcome is decided.
+*/
+func (self *Transaction) runHooks() {
+	var hooks []func()
+	switch self.getStatus() {
+	case successful:
+		hooks = self.onCommit
+	case failed:
+		hooks = self.onAbort
+	default:
+		return
+	}
+	if atomic.CompareAndSwapInt32(&self.hooksRun, 0, 1) {
+		for _, f := range hooks {
+			f()
+		}
+	}
+}
+
 func (self *Transaction) Describe() string {
 	buf := bytes.NewBufferString(fmt.Sprintf("Transaction:%p (beginNumber: %v, commitNumber: %v):\n readHandles:\n", self, self.beginNumber, self.commitNumber))
 	for _, snapshot := range self.readHandles {
@@ -298,13 +538,32 @@ func (self *Transaction) Describe() string {
 
  Any changes made to the return value will *not* be saved when the Transaction commits.
 
- If another Transaction changes the data in h before this Transaction commits the commit will fail.
+ If another Transaction changes the data in h before this Transaction commits the commit will fail, unless this Transaction
+ uses SnapshotIsolation.
 */
 func (self *Transaction) Read(h *Handle) (rval Clonable, err error) {
+	return self.read(h, false)
+}
+
+/*
+ ReadForUpdate is Read, except that the Transaction will fail to commit if the data in h has changed even if it uses SnapshotIsolation.
+
+ Use it for reads that decide what to write, like the links leading to something that will be written.
+*/
+func (self *Transaction) ReadForUpdate(h *Handle) (rval Clonable, err error) {
+	return self.read(h, true)
+}
+func (self *Transaction) read(h *Handle, validate bool) (rval Clonable, err error) {
 	if self.getStatus() != undecided {
 		return nil, fmt.Errorf("%v is not undecided", self)
 	}
+	if self.parent != nil {
+		return self.parent.read(h, validate)
+	}
 	if snapshot, ok := self.readHandles[h]; ok {
+		if validate {
+			snapshot.validate = true
+		}
 		return snapshot.neu.content, nil
 	}
 	if snapshot, ok := self.writeHandles[h]; ok {
@@ -315,7 +574,7 @@ func (self *Transaction) Read(h *Handle) (rval Clonable, err error) {
 		return nil, err
 	}
 	newVersion := oldVersion.clone()
-	self.readHandles[h] = &snapshot{oldVersion, newVersion}
+	self.readHandles[h] = &snapshot{oldVersion, newVersion, validate}
 	return newVersion.content, nil
 }
 
@@ -330,6 +589,9 @@ func (self *Transaction) Write(h *Handle) (rval Clonable, err error) {
 	if self.getStatus() != undecided {
 		return nil, fmt.Errorf("%v is not undecided", self)
 	}
+	if self.parent != nil {
+		return self.parent.Write(h)
+	}
 	if snapshot, ok := self.writeHandles[h]; ok {
 		return snapshot.neu.content, nil
 	}
@@ -343,6 +605,114 @@ func (self *Transaction) Write(h *Handle) (rval Clonable, err error) {
 		return nil, err
 	}
 	newVersion := oldVersion.clone()
-	self.writeHandles[h] = &snapshot{oldVersion, newVersion}
+	self.writeHandles[h] = &snapshot{oldVersion, newVersion, false}
 	return newVersion.content, nil
 }
+
+/*
+ Begin returns a Transaction nested in this one, that reads and writes through it.
+
+ Committing the nested Transaction merges its writes into this one, while aborting it undoes only the writes
+ made since Begin. Neither this nor any enclosing Transaction may be used until the nested one is committed or aborted.
+*/
+func (self *Transaction) Begin() *Transaction {
+	return &Transaction{
+		self.beginNumber,
+		self.commitNumber,
+		undecided,
+		self.isolation,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		0,
+		nil,
+		self,
+		self.Savepoint(),
+	}
+}
+
+/*
+ Savepoint returns a Savepoint that the Transaction can be rolled back to, undoing all writes made after it.
+
+ It copies the content of all handles written so far, so it costs as much as writing them again.
+*/
+func (self *Transaction) Savepoint() *Savepoint {
+	self = self.root()
+	rval := &Savepoint{
+		transaction: self,
+		writes:      make(map[*Handle]Clonable, len(self.writeHandles)),
+		onCommit:    len(self.onCommit),
+		onAbort:     len(self.onAbort),
+	}
+	for handle, snapshot := range self.writeHandles {
+		rval.writes[handle] = snapshot.neu.content.Clone()
+	}
+	self.savepoints = append(self.savepoints, rval)
+	return rval
+}
+
+/*
+ dropSavepoints forgets s and all Savepoints created after it, so that they can't be rolled back to and their copies can be collected.
+*/
+func (self *Transaction) dropSavepoints(s *Savepoint) {
+	for index, savepoint := range self.savepoints {
+		if savepoint == s {
+			self.truncateSavepoints(index)
+			return
+		}
+	}
+}
+
+/*
+ truncateSavepoints forgets all Savepoints from index on, and clears them so they aren't kept alive by the slice.
+*/
+func (self *Transaction) truncateSavepoints(index int) {
+	for i := index; i < len(self.savepoints); i++ {
+		self.savepoints[i] = nil
+	}
+	self.savepoints = self.savepoints[:index]
+}
+
+/*
+ RollbackTo undoes all writes made after s was created, runs the OnAbort functions and drops the OnCommit functions added after it.
+
+ Handles written after s will still be read checked when committing, and Savepoints created after s can't be rolled back to anymore.
+
+ Any Clonable returned by Read or Write before rolling back must be fetched again, since the rolled back content is a copy.
+*/
+func (self *Transaction) RollbackTo(s *Savepoint) error {
+	if self.getStatus() != undecided {
+		return fmt.Errorf("%v is not undecided", self)
+	}
+	self = self.root()
+	if self.getStatus() != undecided {
+		return fmt.Errorf("%v is not undecided", self)
+	}
+	index := -1
+	for i, savepoint := range self.savepoints {
+		if savepoint == s {
+			index = i
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("%v can't be rolled back to %p", self, s)
+	}
+	self.truncateSavepoints(index + 1)
+	for handle, written := range self.writeHandles {
+		if content, ok := s.writes[handle]; ok {
+			written.neu = &version{written.neu.commitNumber, nil, content.Clone()}
+		} else {
+			delete(self.writeHandles, handle)
+			self.readHandles[handle] = &snapshot{written.old, written.old.clone(), written.validate}
+		}
+	}
+	aborted := append([]func(){}, self.onAbort[s.onAbort:]...)
+	self.onCommit = self.onCommit[:s.onCommit]
+	self.onAbort = self.onAbort[:s.onAbort]
+	for _, f := range aborted {
+		f()
+	}
+	return nil
+}
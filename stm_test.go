@@ -723,3 +723,248 @@ func TestSTMCommit(t *testing.T) {
 		t.Errorf("%v should be 'b'", n4.value)
 	}
 }
+
+func TestSTMHooks(t *testing.T) {
+	h := NewHandle(&testNode{"a", nil, nil, nil})
+	var log []string
+	tr := NewTransaction()
+	tr.OnCommit(func() { log = append(log, "commit1") })
+	tr.OnCommit(func() { log = append(log, "commit2") })
+	tr.OnAbort(func() { log = append(log, "abort") })
+	tWrite(t, tr, h).(*testNode).value = "b"
+	tr2 := NewTransaction()
+	tr2.OnCommit(func() { log = append(log, "commit3") })
+	tr2.OnAbort(func() { log = append(log, "abort2") })
+	tWrite(t, tr2, h).(*testNode).value = "c"
+	if !tr.Commit() {
+		t.Errorf("%v should commit", tr)
+	}
+	tr.Commit()
+	tr.Abort()
+	if tr2.Commit() {
+		t.Errorf("%v should not commit", tr2)
+	}
+	tr2.Commit()
+	tr2.Abort()
+	tr.OnCommit(func() { log = append(log, "commit4") })
+	tr.OnAbort(func() { log = append(log, "abort3") })
+	tr3 := NewTransaction()
+	tr3.OnAbort(func() { log = append(log, "abort4") })
+	tr3.Abort()
+	tr3.Abort()
+	expected := []string{"commit1", "commit2", "abort2", "commit4", "abort4"}
+	if fmt.Sprint(log) != fmt.Sprint(expected) {
+		t.Errorf("hooks should have run as %v, but ran as %v", expected, log)
+	}
+}
+
+func TestSTMSavepoint(t *testing.T) {
+	h1 := NewHandle(&testNode{"a", nil, nil, nil})
+	h2 := NewHandle(&testNode{"b", nil, nil, nil})
+	var log []string
+	tr := NewTransaction()
+	tWrite(t, tr, h1).(*testNode).value = "a1"
+	tRead(t, tr, h2)
+	sp := tr.Savepoint()
+	tWrite(t, tr, h1).(*testNode).value = "a2"
+	tWrite(t, tr, h2).(*testNode).value = "b2"
+	tr.OnCommit(func() { log = append(log, "commit") })
+	tr.OnAbort(func() { log = append(log, "abort") })
+	sp2 := tr.Savepoint()
+	if err := tr.RollbackTo(sp); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(log) != "[abort]" {
+		t.Errorf("rolling back should have run the abort hook, but ran %v", log)
+	}
+	if err := tr.RollbackTo(sp2); err == nil {
+		t.Errorf("%v should not roll back to %v after rolling back to an earlier savepoint", tr, sp2)
+	}
+	if v := tRead(t, tr, h1).(*testNode).value; v != "a1" {
+		t.Errorf("%v should be 'a1'", v)
+	}
+	if v := tRead(t, tr, h2).(*testNode).value; v != "b" {
+		t.Errorf("%v should be 'b'", v)
+	}
+	tWrite(t, tr, h1).(*testNode).value = "a3"
+	if err := tr.RollbackTo(sp); err != nil {
+		t.Fatal(err)
+	}
+	if !tr.Commit() {
+		t.Errorf("%v should commit", tr)
+	}
+	if err := tr.RollbackTo(sp); err == nil {
+		t.Errorf("%v should not roll back after committing", tr)
+	}
+	if v := h1.Current().(*testNode).value; v != "a1" {
+		t.Errorf("%v should be 'a1'", v)
+	}
+	if v := h2.Current().(*testNode).value; v != "b" {
+		t.Errorf("%v should be 'b'", v)
+	}
+	if fmt.Sprint(log) != "[abort]" {
+		t.Errorf("committing should not have run any rolled back hooks, but ran %v", log)
+	}
+}
+
+func TestSTMNested(t *testing.T) {
+	h := NewHandle(&testNode{"a", nil, nil, nil})
+	var log []string
+	tr := NewTransaction()
+	tWrite(t, tr, h).(*testNode).value = "b"
+	child := tr.Begin()
+	tWrite(t, child, h).(*testNode).value = "c"
+	child.OnCommit(func() { log = append(log, "child commit") })
+	grandChild := child.Begin()
+	tWrite(t, grandChild, h).(*testNode).value = "d"
+	grandChild.OnCommit(func() { log = append(log, "grand child commit") })
+	grandChild.OnAbort(func() { log = append(log, "grand child abort") })
+	grandChild.Abort()
+	if _, err := grandChild.Write(h); err == nil {
+		t.Errorf("%v should not be writable after aborting", grandChild)
+	}
+	if v := tRead(t, child, h).(*testNode).value; v != "c" {
+		t.Errorf("%v should be 'c'", v)
+	}
+	if !child.Commit() {
+		t.Errorf("%v should commit", child)
+	}
+	if len(tr.savepoints) != 0 {
+		t.Errorf("%v should have dropped the savepoints of its nested transactions, but had %v", tr, tr.savepoints)
+	}
+	if h.Current().(*testNode).value != "a" {
+		t.Errorf("%v should not be visible before the outer transaction commits", h.Current())
+	}
+	if !tr.Commit() {
+		t.Errorf("%v should commit", tr)
+	}
+	if v := h.Current().(*testNode).value; v != "c" {
+		t.Errorf("%v should be 'c'", v)
+	}
+	if fmt.Sprint(log) != "[grand child abort child commit]" {
+		t.Errorf("hooks ran as %v", log)
+	}
+}
+
+func writeSkew(t *testing.T, isolation Isolation) (committed int) {
+	x := NewHandle(&testNode{"1", nil, nil, nil})
+	y := NewHandle(&testNode{"1", nil, nil, nil})
+	tr1 := NewTransactionWithIsolation(isolation)
+	tr2 := NewTransactionWithIsolation(isolation)
+	if tRead(t, tr1, x).(*testNode).value == "1" && tRead(t, tr1, y).(*testNode).value == "1" {
+		tWrite(t, tr1, x).(*testNode).value = "0"
+	}
+	if tRead(t, tr2, x).(*testNode).value == "1" && tRead(t, tr2, y).(*testNode).value == "1" {
+		tWrite(t, tr2, y).(*testNode).value = "0"
+	}
+	for _, tr := range []*Transaction{tr1, tr2} {
+		if tr.Commit() {
+			committed++
+		}
+	}
+	return
+}
+
+func TestSTMIsolationLevels(t *testing.T) {
+	if n := writeSkew(t, Serializable); n != 1 {
+		t.Errorf("only one serializable transaction should commit, but %v did", n)
+	}
+	if n := writeSkew(t, SnapshotIsolation); n != 2 {
+		t.Errorf("both snapshot isolation transactions should commit, but %v did", n)
+	}
+	h := NewHandle(&testNode{"a", nil, nil, nil})
+	tr1 := NewTransactionWithIsolation(SnapshotIsolation)
+	tr2 := NewTransactionWithIsolation(SnapshotIsolation)
+	tWrite(t, tr1, h).(*testNode).value = "b"
+	tWrite(t, tr2, h).(*testNode).value = "c"
+	if !tr1.Commit() {
+		t.Errorf("%v should commit", tr1)
+	}
+	if tr2.Commit() {
+		t.Errorf("%v should not commit after a write-write conflict", tr2)
+	}
+	if child := tr2.Begin(); child.Isolation() != SnapshotIsolation {
+		t.Errorf("%v should use snapshot isolation", child)
+	}
+}
+
+func fiddleMixedIsolation(t *testing.T, id int, treap *Treap, h *TxHash, q *TQueue, done chan []int) {
+	isolation := Serializable
+	if id%2 == 0 {
+		isolation = SnapshotIsolation
+	}
+	var kept []int
+	for j := 0; j < 200; j++ {
+		k := id*1000 + j
+		if err := AtomicallyWithIsolation(isolation, func(tr *Transaction) (err error) {
+			if _, _, err = treap.PutTx(tr, c(k), k); err != nil {
+				return
+			}
+			if _, _, err = h.PutTx(tr, hashInt(k), k); err != nil {
+				return
+			}
+			if j%2 == 1 {
+				var ok1, ok2 bool
+				if _, ok1, err = treap.DeleteTx(tr, c(k-1)); err != nil {
+					return
+				}
+				if _, ok2, err = h.DeleteTx(tr, hashInt(k-1)); err != nil {
+					return
+				}
+				if !ok1 || !ok2 {
+					return fmt.Errorf("%v and %v should contain %v", treap, h, k-1)
+				}
+			}
+			_, err = q.PushTx(tr, k)
+			return
+		}); err != nil {
+			t.Error(err)
+		}
+		if j%2 == 1 {
+			kept = kept[:len(kept)-1]
+		}
+		kept = append(kept, k)
+		runtime.Gosched()
+	}
+	done <- kept
+}
+
+func TestSTMMixedIsolation(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	treap := NewTreap()
+	h := NewTxHash()
+	q := NewTQueue()
+	done := make(chan []int)
+	workers := 4
+	for i := 0; i < workers; i++ {
+		go fiddleMixedIsolation(t, i, treap, h, q, done)
+	}
+	var kept []int
+	for i := 0; i < workers; i++ {
+		kept = append(kept, <-done...)
+	}
+	if treap.Size() != len(kept) || h.Size() != len(kept) {
+		t.Errorf("%v and %v should have size %v", treap, h, len(kept))
+	}
+	for _, k := range kept {
+		if v, ok := treap.Get(c(k)); !ok || v != k {
+			t.Errorf("%v should contain %v, but had %v", treap, k, v)
+		}
+		if v, ok := h.Get(hashInt(k)); !ok || v != k {
+			t.Errorf("%v should contain %v, but had %v", h, k, v)
+		}
+	}
+	if q.Size() != workers*200 {
+		t.Errorf("%v should have size %v", q, workers*200)
+	}
+	popped := make(map[Thing]bool)
+	for v, ok := q.Pop(); ok; v, ok = q.Pop() {
+		if popped[v] {
+			t.Errorf("%v was popped twice", v)
+		}
+		popped[v] = true
+	}
+	if len(popped) != workers*200 {
+		t.Errorf("should have popped %v values, but popped %v", workers*200, len(popped))
+	}
+}
@@ -0,0 +1,237 @@
+package gotomic
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+ An immutable list of values, so that the ends of a TQueue can be cloned without copying their contents.
+*/
+type tQueueNode struct {
+	value Thing
+	next  *tQueueNode
+}
+
+/*
+ One end of a TQueue.
+
+ The read end has its values in order, and the write end has them in reverse order.
+ For bounded TQueues slack is the number of free slots known by this end.
+*/
+type tQueueEnd struct {
+	values *tQueueNode
+	size   int
+	slack  int
+}
+
+func (self *tQueueEnd) Clone() Clonable {
+	rval := *self
+	return &rval
+}
+
+/*
+ TQueue is a FIFO queue that can be used inside a Transaction, so that values can be handed over exactly once
+ together with changes to Treaps, TxHashes or other Handles.
+
+ It is built like the TQueue and TBQueue of Haskell's stm package: producers only write the write end and consumers
+ only write the read end, except when the read end is empty and the write end is reversed into it, or when a bounded
+ TQueue is full from the producers point of view and the slots freed by the consumers are collected.
+
+ The STM has no way of waiting for other Transactions, so PushTx and PopTx report a full or empty TQueue instead of
+ blocking. PushWait and PopWait block, but can't be used inside Transactions.
+*/
+type TQueue struct {
+	read     *Handle
+	write    *Handle
+	capacity int
+	pushed   signal
+	popped   signal
+}
+
+/*
+ NewTQueue returns an unbounded TQueue.
+*/
+func NewTQueue() *TQueue {
+	return NewBoundedTQueue(0)
+}
+
+/*
+ NewBoundedTQueue returns a TQueue that can contain at most capacity values, or an unbounded TQueue if capacity is 0.
+*/
+func NewBoundedTQueue(capacity int) *TQueue {
+	return &TQueue{NewHandle(&tQueueEnd{}), NewHandle(&tQueueEnd{nil, 0, capacity}), capacity, signal{}, signal{}}
+}
+
+func (self *TQueue) String() string {
+	return fmt.Sprintf("&TQueue{%p size:%v capacity:%v}", self, self.Size(), self.capacity)
+}
+
+func ropenTQueueEnd(t *Transaction, h *Handle) (*tQueueEnd, error) {
+	r, err := t.Read(h)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*tQueueEnd), nil
+}
+func wopenTQueueEnd(t *Transaction, h *Handle) (*tQueueEnd, error) {
+	r, err := t.Write(h)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*tQueueEnd), nil
+}
+
+/*
+ SizeTx returns the number of values in the TQueue as seen by t.
+
+ It reads both ends, so it will conflict with all Transactions pushing or popping.
+*/
+func (self *TQueue) SizeTx(t *Transaction) (rval int, err error) {
+	read, err := ropenTQueueEnd(t, self.read)
+	if err != nil {
+		return
+	}
+	write, err := ropenTQueueEnd(t, self.write)
+	if err != nil {
+		return
+	}
+	return read.size + write.size, nil
+}
+
+/*
+ PushTx adds v to the end of the TQueue as part of t, and returns false if the TQueue is bounded and full.
+
+ If it returns an error t has to be aborted (or rolled back) and retried. The value is not visible to others until t commits.
+*/
+func (self *TQueue) PushTx(t *Transaction, v Thing) (ok bool, err error) {
+	write, err := wopenTQueueEnd(t, self.write)
+	if err != nil {
+		return
+	}
+	if self.capacity > 0 && write.slack == 0 {
+		var read *tQueueEnd
+		if read, err = ropenTQueueEnd(t, self.read); err != nil {
+			return
+		}
+		if read.slack == 0 {
+			return false, nil
+		}
+		if read, err = wopenTQueueEnd(t, self.read); err != nil {
+			return
+		}
+		write.slack, read.slack = read.slack, 0
+	}
+	if self.capacity > 0 {
+		write.slack--
+	}
+	write.values = &tQueueNode{v, write.values}
+	write.size++
+	t.OnCommit(self.pushed.notify)
+	return true, nil
+}
+
+/*
+ PopTx removes and returns the first value of the TQueue as part of t, and returns false if the TQueue is empty.
+
+ If it returns an error t has to be aborted (or rolled back) and retried. The value is not removed for others until t commits.
+*/
+func (self *TQueue) PopTx(t *Transaction) (v Thing, ok bool, err error) {
+	read, err := ropenTQueueEnd(t, self.read)
+	if err != nil {
+		return
+	}
+	if read.values == nil {
+		var write *tQueueEnd
+		if write, err = ropenTQueueEnd(t, self.write); err != nil {
+			return
+		}
+		if write.values == nil {
+			return
+		}
+		if write, err = wopenTQueueEnd(t, self.write); err != nil {
+			return
+		}
+		if read, err = wopenTQueueEnd(t, self.read); err != nil {
+			return
+		}
+		for node := write.values; node != nil; node = node.next {
+			read.values = &tQueueNode{node.value, read.values}
+		}
+		read.size, write.values, write.size = write.size, nil, 0
+	} else if read, err = wopenTQueueEnd(t, self.read); err != nil {
+		return
+	}
+	v, ok = read.values.value, true
+	read.values = read.values.next
+	read.size--
+	if self.capacity > 0 {
+		read.slack++
+	}
+	t.OnCommit(self.popped.notify)
+	return
+}
+
+/*
+ Size returns the number of values in the TQueue.
+*/
+func (self *TQueue) Size() (rval int) {
+	Atomically(func(t *Transaction) (err error) {
+		rval, err = self.SizeTx(t)
+		return
+	})
+	return
+}
+
+/*
+ Push adds v to the end of the TQueue, and returns false if the TQueue is bounded and full.
+*/
+func (self *TQueue) Push(v Thing) (ok bool) {
+	Atomically(func(t *Transaction) (err error) {
+		ok, err = self.PushTx(t, v)
+		return
+	})
+	return
+}
+
+/*
+ Pop removes and returns the first value of the TQueue, and returns false if it is empty.
+*/
+func (self *TQueue) Pop() (v Thing, ok bool) {
+	Atomically(func(t *Transaction) (err error) {
+		v, ok, err = self.PopTx(t)
+		return
+	})
+	return
+}
+
+/*
+ PushWait adds v to the end of the TQueue, waiting for a value to be popped if it is full, until ctx is done.
+*/
+func (self *TQueue) PushWait(ctx context.Context, v Thing) error {
+	return self.popped.wait(ctx, func() bool {
+		return self.Push(v)
+	})
+}
+
+/*
+ PopWait removes and returns the first value of the TQueue, waiting for a value to be pushed if it is empty, until ctx is done.
+*/
+func (self *TQueue) PopWait(ctx context.Context) (rval Thing, err error) {
+	err = self.pushed.wait(ctx, func() (ok bool) {
+		rval, ok = self.Pop()
+		return
+	})
+	return
+}
+
+/*
+ TryPopTimeout removes and returns the first value of the TQueue, waiting at most timeout for a value to be pushed if it is empty.
+*/
+func (self *TQueue) TryPopTimeout(timeout time.Duration) (rval Thing, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rval, err := self.PopWait(ctx)
+	return rval, err == nil
+}
@@ -0,0 +1,148 @@
+package gotomic
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTQueue(t *testing.T) {
+	q := NewTQueue()
+	if _, ok := q.Pop(); ok {
+		t.Errorf("%v should be empty", q)
+	}
+	for i := 0; i < 10; i++ {
+		if !q.Push(i) {
+			t.Errorf("%v should accept %v", q, i)
+		}
+		if i == 4 {
+			if v, ok := q.Pop(); !ok || v != 0 {
+				t.Errorf("%v should pop 0, but got %v, %v", q, v, ok)
+			}
+		}
+	}
+	if q.Size() != 9 {
+		t.Errorf("%v should have size 9", q)
+	}
+	for i := 1; i < 10; i++ {
+		if v, ok := q.Pop(); !ok || v != i {
+			t.Errorf("%v should pop %v, but got %v, %v", q, i, v, ok)
+		}
+	}
+	if q.Size() != 0 {
+		t.Errorf("%v should be empty", q)
+	}
+}
+
+func TestBoundedTQueue(t *testing.T) {
+	q := NewBoundedTQueue(3)
+	for i := 0; i < 3; i++ {
+		if !q.Push(i) {
+			t.Errorf("%v should accept %v", q, i)
+		}
+	}
+	if q.Push(3) {
+		t.Errorf("%v should be full", q)
+	}
+	q.Pop()
+	q.Pop()
+	if !q.Push(3) || !q.Push(4) || q.Push(5) {
+		t.Errorf("%v should accept exactly two more values", q)
+	}
+	for i := 2; i < 5; i++ {
+		if v, ok := q.Pop(); !ok || v != i {
+			t.Errorf("%v should pop %v, but got %v, %v", q, i, v, ok)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	q.Push(0)
+	q.Push(1)
+	q.Push(2)
+	if err := q.PushWait(ctx, 3); err == nil {
+		t.Errorf("%v should time out while full", q)
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		q.Pop()
+	}()
+	if err := q.PushWait(context.Background(), 3); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTQueueTx(t *testing.T) {
+	q := NewTQueue()
+	q.Push("a")
+	tr := NewTransaction()
+	if v, ok, err := q.PopTx(tr); err != nil || !ok || v != "a" {
+		t.Errorf("%v should pop a, but got %v, %v, %v", q, v, ok, err)
+	}
+	if _, err := q.PushTx(tr, "b"); err != nil {
+		t.Error(err)
+	}
+	tr.Abort()
+	if v, ok := q.Pop(); !ok || v != "a" {
+		t.Errorf("%v should still contain a, but popped %v, %v", q, v, ok)
+	}
+	if _, ok := q.Pop(); ok {
+		t.Errorf("%v should not contain b", q)
+	}
+	if _, ok := q.TryPopTimeout(time.Millisecond); ok {
+		t.Errorf("%v should time out while empty", q)
+	}
+}
+
+func consumeTQueue(t *testing.T, q *TQueue, seen *Treap, done chan bool) {
+	for {
+		var v Thing
+		var ok bool
+		err := Atomically(func(tr *Transaction) (err error) {
+			if v, ok, err = q.PopTx(tr); err != nil || !ok || v == nil {
+				return
+			}
+			_, dup, err := seen.PutTx(tr, v.(c), true)
+			if err == nil && dup {
+				t.Errorf("%v was handed over twice", v)
+			}
+			return
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if ok && v == nil {
+			break
+		}
+		if !ok {
+			q.pushed.wait(context.Background(), func() bool {
+				return q.Size() > 0
+			})
+		}
+	}
+	done <- true
+}
+
+func TestTQueueConc(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	q := NewBoundedTQueue(16)
+	seen := NewTreap()
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go consumeTQueue(t, q, seen, done)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := q.PushWait(context.Background(), c(i)); err != nil {
+			t.Error(err)
+		}
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		q.PushWait(context.Background(), nil)
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	if seen.Size() != 1000 {
+		t.Errorf("%v should have seen 1000 values", seen)
+	}
+}
@@ -99,6 +99,17 @@ func (self *Treap) ropen(t *Transaction) (*treap, error) {
 	return r.(*treap), nil
 }
 
+/*
+ Get a readable *treap from the Treap, that will be validated when committing since we are going to change the Treap
+*/
+func (self *Treap) uopen(t *Transaction) (*treap, error) {
+	r, err := t.ReadForUpdate(self.handle)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*treap), nil
+}
+
 /*
  Get a writable *treap from the Treap
 */
@@ -109,6 +120,9 @@ func (self *Treap) wopen(t *Transaction) (*treap, error) {
 	}
 	return r.(*treap), nil
 }
+func (treap *Treap) Size() int {
+	return int(atomic.LoadInt64(&treap.size))
+}
 func (treap *Treap) Describe() string {
 	rval, err := treap.describe()
 	for err != nil {
@@ -140,7 +154,22 @@ func (treap *Treap) Delete(k Comparable) (old Thing, ok bool) {
 }
 func (treap *Treap) del(k Comparable) (old Thing, ok bool, err error) {
 	t := NewTransaction()
-	self, err := treap.ropen(t)
+	if old, ok, err = treap.DeleteTx(t, k); err != nil {
+		return
+	}
+	if !t.Commit() {
+		err = fmt.Errorf("%v changed during delete", treap)
+	}
+	return
+}
+
+/*
+ DeleteTx removes k from the Treap as part of t, and returns any value it removed.
+
+ If it returns an error t has to be aborted (or rolled back) and retried. The change is not visible to others until t commits.
+*/
+func (treap *Treap) DeleteTx(t *Transaction, k Comparable) (old Thing, ok bool, err error) {
+	self, err := treap.uopen(t)
 	if err != nil {
 		return
 	}
@@ -159,10 +188,10 @@ func (treap *Treap) del(k Comparable) (old Thing, ok bool, err error) {
 		}
 		self.root = newRoot
 	}
-	if t.Commit() {
-		atomic.AddInt64(&treap.size, -1)
-	} else {
-		err = fmt.Errorf("%v changed during delete", treap)
+	if ok {
+		t.OnCommit(func() {
+			atomic.AddInt64(&treap.size, -1)
+		})
 	}
 	return
 }
@@ -187,7 +216,15 @@ func (treap *Treap) ToSlice() (keys []Comparable, values []Thing) {
 	return
 }
 func (treap *Treap) Each(iter TreapIterator) (err error) {
-	t := NewTransaction()
+	return treap.EachTx(NewTransaction(), iter)
+}
+
+/*
+ EachTx runs iter on each key and value in order, as seen by t.
+
+ If it returns an error t has to be aborted (or rolled back) and retried.
+*/
+func (treap *Treap) EachTx(t *Transaction, iter TreapIterator) (err error) {
 	self, err := treap.ropen(t)
 	if err != nil {
 		return
@@ -206,7 +243,15 @@ func (treap *Treap) Next(k Comparable) (key Comparable, value Thing, ok bool) {
 	return
 }
 func (treap *Treap) next(k Comparable) (key Comparable, value Thing, ok bool, err error) {
-	t := NewTransaction()
+	return treap.NextTx(NewTransaction(), k)
+}
+
+/*
+ NextTx returns the key and value after k, as seen by t.
+
+ If it returns an error t has to be aborted (or rolled back) and retried.
+*/
+func (treap *Treap) NextTx(t *Transaction, k Comparable) (key Comparable, value Thing, ok bool, err error) {
 	self, err := treap.ropen(t)
 	if err != nil {
 		return
@@ -230,7 +275,15 @@ func (treap *Treap) Previous(k Comparable) (key Comparable, value Thing, ok bool
 	return
 }
 func (treap *Treap) previous(k Comparable) (key Comparable, value Thing, ok bool, err error) {
-	t := NewTransaction()
+	return treap.PreviousTx(NewTransaction(), k)
+}
+
+/*
+ PreviousTx returns the key and value before k, as seen by t.
+
+ If it returns an error t has to be aborted (or rolled back) and retried.
+*/
+func (treap *Treap) PreviousTx(t *Transaction, k Comparable) (key Comparable, value Thing, ok bool, err error) {
 	self, err := treap.ropen(t)
 	if err != nil {
 		return
@@ -254,7 +307,15 @@ func (treap *Treap) Get(k Comparable) (v Thing, ok bool) {
 	return
 }
 func (treap *Treap) get(k Comparable) (v Thing, ok bool, err error) {
-	t := NewTransaction()
+	return treap.GetTx(NewTransaction(), k)
+}
+
+/*
+ GetTx returns the value at k as seen by t, including any changes t has made.
+
+ If it returns an error t has to be aborted (or rolled back) and retried.
+*/
+func (treap *Treap) GetTx(t *Transaction, k Comparable) (v Thing, ok bool, err error) {
 	self, err := treap.ropen(t)
 	if err != nil {
 		return
@@ -276,7 +337,15 @@ func (treap *Treap) Min() (k Comparable, v Thing, ok bool) {
 	return
 }
 func (treap *Treap) min() (k Comparable, v Thing, ok bool, err error) {
-	t := NewTransaction()
+	return treap.MinTx(NewTransaction())
+}
+
+/*
+ MinTx returns the smallest key and its value, as seen by t.
+
+ If it returns an error t has to be aborted (or rolled back) and retried.
+*/
+func (treap *Treap) MinTx(t *Transaction) (k Comparable, v Thing, ok bool, err error) {
 	self, err := treap.ropen(t)
 	if err != nil {
 		return
@@ -297,7 +366,15 @@ func (treap *Treap) Max() (k Comparable, v Thing, ok bool) {
 	return
 }
 func (treap *Treap) max() (k Comparable, v Thing, ok bool, err error) {
-	t := NewTransaction()
+	return treap.MaxTx(NewTransaction())
+}
+
+/*
+ MaxTx returns the largest key and its value, as seen by t.
+
+ If it returns an error t has to be aborted (or rolled back) and retried.
+*/
+func (treap *Treap) MaxTx(t *Transaction) (k Comparable, v Thing, ok bool, err error) {
 	self, err := treap.ropen(t)
 	if err != nil {
 		return
@@ -312,7 +389,22 @@ func (treap *Treap) max() (k Comparable, v Thing, ok bool, err error) {
 }
 func (treap *Treap) put(k Comparable, v Thing) (old Thing, ok bool, err error) {
 	t := NewTransaction()
-	self, err := treap.ropen(t)
+	if old, ok, err = treap.PutTx(t, k, v); err != nil {
+		return
+	}
+	if !t.Commit() {
+		err = fmt.Errorf("%v changed during put", treap)
+	}
+	return
+}
+
+/*
+ PutTx puts k and v in the Treap as part of t, and returns the overwritten value and whether any value was overwritten.
+
+ If it returns an error t has to be aborted (or rolled back) and retried. The change is not visible to others until t commits.
+*/
+func (treap *Treap) PutTx(t *Transaction, k Comparable, v Thing) (old Thing, ok bool, err error) {
+	self, err := treap.uopen(t)
 	if err != nil {
 		return
 	}
@@ -328,10 +420,10 @@ func (treap *Treap) put(k Comparable, v Thing) (old Thing, ok bool, err error) {
 		}
 		self.root = newRoot
 	}
-	if t.Commit() {
-		atomic.AddInt64(&treap.size, 1)
-	} else {
-		err = fmt.Errorf("%v changed during put", treap)
+	if !ok {
+		t.OnCommit(func() {
+			atomic.AddInt64(&treap.size, 1)
+		})
 	}
 	return
 }
@@ -360,6 +452,13 @@ func (handle *nodeHandle) ropen(t *Transaction) (*node, error) {
 	}
 	return n.(*node), nil
 }
+func (handle *nodeHandle) uopen(t *Transaction) (*node, error) {
+	n, err := t.ReadForUpdate((*Handle)(handle.Handle))
+	if err != nil {
+		return nil, err
+	}
+	return n.(*node), nil
+}
 func (handle *nodeHandle) wopen(t *Transaction) (*node, error) {
 	r, err := t.Write((*Handle)(handle.Handle))
 	if err != nil {
@@ -519,7 +618,7 @@ func (handle *nodeHandle) del(t *Transaction, k Comparable) (result *nodeHandle,
 		return
 	}
 	result = handle
-	self, err := handle.ropen(t)
+	self, err := handle.uopen(t)
 	if err != nil {
 		return
 	}
@@ -567,7 +666,7 @@ func (handle *nodeHandle) insert(t *Transaction, newHandle *nodeHandle) (result
 		return
 	}
 	result = handle
-	self, err := handle.ropen(t)
+	self, err := handle.uopen(t)
 	if err != nil {
 		return
 	}
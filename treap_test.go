@@ -117,6 +117,7 @@ func TestTreapPutGetDelete(t *testing.T) {
 	if ok {
 		t.Error("should not contain 3")
 	}
+	treap.Put(c(3), 43)
 	treap.Put(c(3), 44)
 	v, ok := treap.Get(c(3))
 	if !ok {
@@ -125,6 +126,9 @@ func TestTreapPutGetDelete(t *testing.T) {
 	if v != 44 {
 		t.Error("should be 44")
 	}
+	if treap.Size() != 1 {
+		t.Error("should have size 1")
+	}
 	v, ok = treap.Delete(c(3))
 	if !ok {
 		t.Error("should contain 3")
@@ -143,6 +147,9 @@ func TestTreapPutGetDelete(t *testing.T) {
 	if ok {
 		t.Error("should not contain 3")
 	}
+	if treap.Size() != 0 {
+		t.Error("should have size 0")
+	}
 }
 
 func assertTreapSlice(t *testing.T, treap *Treap, keys []Comparable, values []Thing) {
@@ -312,3 +319,166 @@ func BenchmarkTreapConc(b *testing.B) {
 	}
 	runtime.GOMAXPROCS(1)
 }
+
+func TestTreapNested(t *testing.T) {
+	treap := NewTreap()
+	treap.Put(c(1), "a")
+	tr := NewTransaction()
+	if _, _, err := treap.PutTx(tr, c(2), "b"); err != nil {
+		t.Fatal(err)
+	}
+	step := tr.Begin()
+	if _, _, err := treap.PutTx(step, c(3), "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := treap.DeleteTx(step, c(1)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, _ := treap.GetTx(step, c(3)); !ok || v != "c" {
+		t.Errorf("%v should contain c(3) within %v", treap, step)
+	}
+	step.Abort()
+	if _, ok, _ := treap.GetTx(tr, c(3)); ok {
+		t.Errorf("%v should not contain c(3) within %v", treap, tr)
+	}
+	if _, ok := treap.Get(c(2)); ok {
+		t.Errorf("%v should not contain c(2) before %v commits", treap, tr)
+	}
+	if !tr.Commit() {
+		t.Errorf("%v should commit", tr)
+	}
+	keys, values := treap.ToSlice()
+	if !reflect.DeepEqual(keys, []Comparable{c(1), c(2)}) || !reflect.DeepEqual(values, []Thing{"a", "b"}) {
+		t.Errorf("%v should contain 1 => a and 2 => b, but contained %v => %v", treap, keys, values)
+	}
+	if treap.Size() != 2 {
+		t.Errorf("%v should have size 2", treap)
+	}
+}
+
+type txCounter int
+
+func (self *txCounter) Clone() Clonable {
+	rval := *self
+	return &rval
+}
+
+func fiddleIndexedTreaps(t *testing.T, primary, secondary *Treap, moves *Handle, do, done chan bool) {
+	<-do
+	for i := 0; i < 100; i++ {
+		k1 := c(rand.Int() % 10)
+		k2 := c(rand.Int() % 10)
+		err := Atomically(func(tr *Transaction) error {
+			v1, _, err := primary.GetTx(tr, k1)
+			if err != nil {
+				return err
+			}
+			v2, _, err := primary.GetTx(tr, k2)
+			if err != nil {
+				return err
+			}
+			for _, kv := range [][2]Thing{{k1, v2}, {k2, v1}} {
+				if _, _, err = primary.PutTx(tr, kv[0].(c), kv[1]); err != nil {
+					return err
+				}
+				if _, _, err = secondary.PutTx(tr, kv[1].(s), kv[0]); err != nil {
+					return err
+				}
+			}
+			counter, err := tr.Write(moves)
+			if err != nil {
+				return err
+			}
+			*counter.(*txCounter)++
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	done <- true
+}
+
+func TestTreapAtomically(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	primary := NewTreap()
+	secondary := NewTreap()
+	for i := 0; i < 10; i++ {
+		primary.Put(c(i), s(fmt.Sprint(i)))
+		secondary.Put(s(fmt.Sprint(i)), c(i))
+	}
+	moves := NewHandle(new(txCounter))
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go fiddleIndexedTreaps(t, primary, secondary, moves, do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	if n := int(*moves.Current().(*txCounter)); n != 100*runtime.NumCPU() {
+		t.Errorf("should have made %v moves, but made %v", 100*runtime.NumCPU(), n)
+	}
+	if primary.Size() != 10 || secondary.Size() != 10 {
+		t.Errorf("%v and %v should have size 10", primary, secondary)
+	}
+	primary.Each(func(k Comparable, v Thing) {
+		if back, ok := secondary.Get(v.(s)); !ok || back != k {
+			t.Errorf("%v should map %v to %v, but had %v", secondary, v, k, back)
+		}
+	})
+	if err := Atomically(func(tr *Transaction) error {
+		primary.PutTx(tr, c(100), s("100"))
+		return fmt.Errorf("nope")
+	}); err == nil || err.Error() != "nope" {
+		t.Errorf("Atomically should return the error, but returned %v", err)
+	}
+	if _, ok := primary.Get(c(100)); ok {
+		t.Errorf("%v should not contain c(100)", primary)
+	}
+	attempts := 0
+	if err := Atomically(func(tr *Transaction) error {
+		attempts++
+		if attempts == 1 {
+			return fmt.Errorf("wrapped: %w", ConflictError{"conflict"})
+		}
+		return nil
+	}); err != nil || attempts != 2 {
+		t.Errorf("Atomically should retry wrapped conflicts, but returned %v after %v attempts", err, attempts)
+	}
+}
+
+func TestTreapSnapshotIsolation(t *testing.T) {
+	for round := 0; round < 100; round++ {
+		treap := NewTreap()
+		for i := 0; i < 100; i++ {
+			treap.Put(c(i*2), i*2)
+		}
+		attempts := 0
+		if err := AtomicallyWithIsolation(SnapshotIsolation, func(tr *Transaction) (err error) {
+			attempts++
+			if _, _, err = treap.PutTx(tr, c(101), 101); err != nil {
+				return
+			}
+			if attempts == 1 {
+				/*
+				 If 100 is where 101 was inserted, this doesn't write anything the Transaction wrote.
+				*/
+				treap.Delete(c(100))
+			}
+			return
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if attempts != 2 {
+			t.Errorf("should retry once after 100 was deleted, but made %v attempts", attempts)
+		}
+		if v, ok := treap.Get(c(101)); !ok || v != 101 {
+			t.Fatalf("%v should contain 101 => 101, but had %v", treap.Describe(), v)
+		}
+		if keys, _ := treap.ToSlice(); treap.Size() != 100 || len(keys) != 100 {
+			t.Errorf("%v should have size 100", treap)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package gotomic
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+const tx_hash_initial_buckets = 16
+
+/*
+ How many entries per bucket a TxHash can have on average before it grows.
+*/
+const tx_hash_load_factor = 2
+
+type txHashEntry struct {
+	key   Hashable
+	value Thing
+}
+
+/*
+ The entries with hash codes ending up in one bucket.
+*/
+type txHashBucket struct {
+	entries []txHashEntry
+}
+
+func (self *txHashBucket) Clone() Clonable {
+	return &txHashBucket{append([]txHashEntry(nil), self.entries...)}
+}
+func (self *txHashBucket) find(k Hashable) int {
+	for index, e := range self.entries {
+		if k.Equals(e.key) {
+			return index
+		}
+	}
+	return -1
+}
+
+/*
+ The buckets of a TxHash. The slice is never changed, only replaced when growing.
+*/
+type txHashTable struct {
+	buckets []*Handle
+}
+
+func (self *txHashTable) Clone() Clonable {
+	rval := *self
+	return &rval
+}
+func (self *txHashTable) bucket(k Hashable) *Handle {
+	return self.buckets[k.HashCode()%uint32(len(self.buckets))]
+}
+
+/*
+ TxHash is a hash table that can be used inside a Transaction, so that it can be changed atomically
+ together with other TxHashes, Treaps or Handles.
+
+ Each bucket is a Handle, and the buckets are kept in a table that is itself a Handle. Operations only read the table
+ (using ReadForUpdate if they change the TxHash) and read or write their bucket, so operations on keys in different
+ buckets don't conflict with each other.
+ When the TxHash grows the table is replaced, which makes all Transactions using the old table retry.
+
+ Like Treap it is thread safe and non blocking, but every operation copies the bucket it uses.
+*/
+type TxHash struct {
+	handle *Handle
+	size   int64
+}
+
+func NewTxHash() *TxHash {
+	return &TxHash{NewHandle(newTxHashTable(tx_hash_initial_buckets)), 0}
+}
+func newTxHashTable(buckets int) *txHashTable {
+	rval := &txHashTable{make([]*Handle, buckets)}
+	for index := range rval.buckets {
+		rval.buckets[index] = NewHandle(&txHashBucket{})
+	}
+	return rval
+}
+func (self *TxHash) ropen(t *Transaction) (*txHashTable, error) {
+	r, err := t.Read(self.handle)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*txHashTable), nil
+}
+func (self *TxHash) uopen(t *Transaction) (*txHashTable, error) {
+	r, err := t.ReadForUpdate(self.handle)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*txHashTable), nil
+}
+func ropenTxHashBucket(t *Transaction, h *Handle) (*txHashBucket, error) {
+	r, err := t.Read(h)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*txHashBucket), nil
+}
+func wopenTxHashBucket(t *Transaction, h *Handle) (*txHashBucket, error) {
+	r, err := t.Write(h)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*txHashBucket), nil
+}
+
+func (self *TxHash) Size() int {
+	return int(atomic.LoadInt64(&self.size))
+}
+func (self *TxHash) String() string {
+	return fmt.Sprint(self.ToMap())
+}
+func (self *TxHash) Describe() string {
+	t := NewTransaction()
+	table, err := self.ropen(t)
+	for err != nil {
+		t = NewTransaction()
+		table, err = self.ropen(t)
+	}
+	buf := bytes.NewBufferString(fmt.Sprintf("&TxHash{%p size:%v buckets:%v}\n", self, self.Size(), len(table.buckets)))
+	for index, h := range table.buckets {
+		fmt.Fprintf(buf, " %v: %v\n", index, h.Current().(*txHashBucket).entries)
+	}
+	return string(buf.Bytes())
+}
+
+/*
+ addSize is run when a Transaction changing the size commits, and grows the TxHash if it has become too crowded.
+*/
+func (self *TxHash) addSize(i int64, buckets int) {
+	if atomic.AddInt64(&self.size, i) > int64(buckets*tx_hash_load_factor) {
+		self.grow(buckets)
+	}
+}
+
+/*
+ grow doubles the number of buckets, unless someone else already grew it past buckets.
+*/
+func (self *TxHash) grow(buckets int) {
+	Atomically(func(t *Transaction) error {
+		table, err := t.Write(self.handle)
+		if err != nil {
+			return err
+		}
+		old := table.(*txHashTable)
+		if len(old.buckets) > buckets {
+			return nil
+		}
+		neu := newTxHashTable(len(old.buckets) * 2)
+		for _, h := range old.buckets {
+			bucket, err := ropenTxHashBucket(t, h)
+			if err != nil {
+				return err
+			}
+			for _, e := range bucket.entries {
+				/*
+				 The new buckets aren't shared yet, so they can be changed outside the Transaction.
+				*/
+				b := neu.bucket(e.key).Current().(*txHashBucket)
+				b.entries = append(b.entries, e)
+			}
+		}
+		old.buckets = neu.buckets
+		return nil
+	})
+}
+
+/*
+ GetTx returns the value at k as seen by t, including any changes t has made.
+
+ If it returns an error t has to be aborted (or rolled back) and retried.
+*/
+func (self *TxHash) GetTx(t *Transaction, k Hashable) (v Thing, ok bool, err error) {
+	table, err := self.ropen(t)
+	if err != nil {
+		return
+	}
+	bucket, err := ropenTxHashBucket(t, table.bucket(k))
+	if err != nil {
+		return
+	}
+	if index := bucket.find(k); index != -1 {
+		v, ok = bucket.entries[index].value, true
+	}
+	return
+}
+
+/*
+ PutTx puts k and v in the TxHash as part of t, and returns the overwritten value and whether any value was overwritten.
+
+ If it returns an error t has to be aborted (or rolled back) and retried. The change is not visible to others until t commits.
+*/
+func (self *TxHash) PutTx(t *Transaction, k Hashable, v Thing) (old Thing, ok bool, err error) {
+	table, err := self.uopen(t)
+	if err != nil {
+		return
+	}
+	bucket, err := wopenTxHashBucket(t, table.bucket(k))
+	if err != nil {
+		return
+	}
+	if index := bucket.find(k); index != -1 {
+		old, ok = bucket.entries[index].value, true
+		bucket.entries[index].value = v
+		return
+	}
+	bucket.entries = append(bucket.entries, txHashEntry{k, v})
+	buckets := len(table.buckets)
+	t.OnCommit(func() {
+		self.addSize(1, buckets)
+	})
+	return
+}
+
+/*
+ DeleteTx removes k from the TxHash as part of t, and returns any value it removed.
+
+ If it returns an error t has to be aborted (or rolled back) and retried. The change is not visible to others until t commits.
+*/
+func (self *TxHash) DeleteTx(t *Transaction, k Hashable) (old Thing, ok bool, err error) {
+	table, err := self.uopen(t)
+	if err != nil {
+		return
+	}
+	bucket, err := ropenTxHashBucket(t, table.bucket(k))
+	if err != nil {
+		return
+	}
+	index := bucket.find(k)
+	if index == -1 {
+		return
+	}
+	if bucket, err = wopenTxHashBucket(t, table.bucket(k)); err != nil {
+		return
+	}
+	old, ok = bucket.entries[index].value, true
+	last := len(bucket.entries) - 1
+	bucket.entries[index] = bucket.entries[last]
+	bucket.entries = bucket.entries[:last]
+	t.OnCommit(func() {
+		atomic.AddInt64(&self.size, -1)
+	})
+	return
+}
+
+/*
+ EachTx runs iter on each key and value as seen by t, until iter returns true.
+
+ If it returns an error t has to be aborted (or rolled back) and retried.
+*/
+func (self *TxHash) EachTx(t *Transaction, iter HashIterator) (err error) {
+	table, err := self.ropen(t)
+	if err != nil {
+		return
+	}
+	for _, h := range table.buckets {
+		var bucket *txHashBucket
+		if bucket, err = ropenTxHashBucket(t, h); err != nil {
+			return
+		}
+		for _, e := range bucket.entries {
+			if iter(e.key, e.value) {
+				return
+			}
+		}
+	}
+	return
+}
+
+/*
+ Get returns the value at k and whether it was present in the TxHash.
+*/
+func (self *TxHash) Get(k Hashable) (v Thing, ok bool) {
+	v, ok, err := self.GetTx(NewTransaction(), k)
+	for err != nil {
+		v, ok, err = self.GetTx(NewTransaction(), k)
+	}
+	return
+}
+
+/*
+ Put k and v in the TxHash and return the overwritten value and whether any value was overwritten.
+*/
+func (self *TxHash) Put(k Hashable, v Thing) (old Thing, ok bool) {
+	Atomically(func(t *Transaction) (err error) {
+		old, ok, err = self.PutTx(t, k, v)
+		return
+	})
+	return
+}
+
+/*
+ Delete removes k from the TxHash and returns any value it removed.
+*/
+func (self *TxHash) Delete(k Hashable) (old Thing, ok bool) {
+	Atomically(func(t *Transaction) (err error) {
+		old, ok, err = self.DeleteTx(t, k)
+		return
+	})
+	return
+}
+
+/*
+ ToMap returns a consistent snapshot of the TxHash.
+*/
+func (self *TxHash) ToMap() (rval map[Hashable]Thing) {
+	Atomically(func(t *Transaction) error {
+		rval = make(map[Hashable]Thing)
+		return self.EachTx(t, func(k Hashable, v Thing) bool {
+			rval[k] = v
+			return false
+		})
+	})
+	return
+}
@@ -0,0 +1,151 @@
+package gotomic
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestTxHash(t *testing.T) {
+	h := NewTxHash()
+	cmp := make(map[Hashable]Thing)
+	for i := 0; i < 1000; i++ {
+		k := hashInt(i)
+		if old, ok := h.Put(k, i); ok {
+			t.Errorf("%v should not contain %v, but had %v", h.Describe(), k, old)
+		}
+		cmp[k] = i
+	}
+	for i := 0; i < 1000; i += 2 {
+		k := hashInt(i)
+		if old, ok := h.Put(k, -i); !ok || old != i {
+			t.Errorf("%v should have replaced %v at %v, but got %v, %v", h.Describe(), i, k, old, ok)
+		}
+		cmp[k] = -i
+	}
+	for i := 0; i < 1000; i += 3 {
+		k := hashInt(i)
+		if old, ok := h.Delete(k); !ok || old != cmp[k] {
+			t.Errorf("%v should have deleted %v at %v, but got %v, %v", h.Describe(), cmp[k], k, old, ok)
+		}
+		delete(cmp, k)
+	}
+	if _, ok := h.Delete(hashInt(3)); ok {
+		t.Errorf("%v should not contain %v", h.Describe(), hashInt(3))
+	}
+	for k, v := range cmp {
+		if got, ok := h.Get(k); !ok || got != v {
+			t.Errorf("%v should contain %v at %v, but got %v, %v", h.Describe(), v, k, got, ok)
+		}
+	}
+	if h.Size() != len(cmp) {
+		t.Errorf("%v should have size %v", h.Describe(), len(cmp))
+	}
+	if m := h.ToMap(); !reflect.DeepEqual(m, cmp) {
+		t.Errorf("%v should equal %v", m, cmp)
+	}
+	if n := len(h.handle.Current().(*txHashTable).buckets); n <= tx_hash_initial_buckets {
+		t.Errorf("%v should have grown, but has %v buckets", h.Describe(), n)
+	}
+}
+
+func transferTx(t *testing.T, accounts *TxHash, log *Treap, x int, do, done chan bool) {
+	<-do
+	for i := 0; i < 200; i++ {
+		from := hashInt((x + i) % 10)
+		to := hashInt((x * i) % 10)
+		if from == to {
+			continue
+		}
+		err := Atomically(func(tr *Transaction) error {
+			balance, _, err := accounts.GetTx(tr, from)
+			if err != nil {
+				return err
+			}
+			if balance.(int) < 10 {
+				return nil
+			}
+			other, _, err := accounts.GetTx(tr, to)
+			if err != nil {
+				return err
+			}
+			if _, _, err = accounts.PutTx(tr, from, balance.(int)-10); err != nil {
+				return err
+			}
+			if _, _, err = accounts.PutTx(tr, to, other.(int)+10); err != nil {
+				return err
+			}
+			count, _, err := log.GetTx(tr, c(x))
+			if err != nil {
+				return err
+			}
+			_, _, err = log.PutTx(tr, c(x), count.(int)+1)
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	done <- true
+}
+
+func TestTxHashConc(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	accounts := NewTxHash()
+	log := NewTreap()
+	for i := 0; i < 10; i++ {
+		accounts.Put(hashInt(i), 100)
+	}
+	do := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		log.Put(c(i), 0)
+		go transferTx(t, accounts, log, i, do, done)
+	}
+	close(do)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		<-done
+	}
+	sum := 0
+	for _, v := range accounts.ToMap() {
+		sum += v.(int)
+	}
+	if sum != 1000 {
+		t.Errorf("%v should contain 1000 in total, but contained %v", accounts, sum)
+	}
+	if accounts.Size() != 10 {
+		t.Errorf("%v should have size 10", accounts)
+	}
+}
+
+func TestTxHashSnapshotIsolation(t *testing.T) {
+	h := NewTxHash()
+	n := tx_hash_initial_buckets * tx_hash_load_factor * 2
+	attempts := 0
+	if err := AtomicallyWithIsolation(SnapshotIsolation, func(tr *Transaction) (err error) {
+		attempts++
+		if _, _, err = h.PutTx(tr, hashInt(-1), -1); err != nil {
+			return
+		}
+		if attempts == 1 {
+			/*
+			 Grow it without touching the bucket of -1.
+			*/
+			for i := 0; i < n; i++ {
+				h.Put(hashInt(i*tx_hash_initial_buckets), i)
+			}
+		}
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("should retry once after %v grew, but made %v attempts", h, attempts)
+	}
+	if v, ok := h.Get(hashInt(-1)); !ok || v != -1 {
+		t.Errorf("%v should contain -1 => -1, but had %v", h.Describe(), v)
+	}
+	if h.Size() != n+1 || len(h.ToMap()) != n+1 {
+		t.Errorf("%v should have size %v", h, n+1)
+	}
+}
@@ -0,0 +1,349 @@
+package gotomic
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+ How many locks a watched Hash spreads its writers over.
+*/
+const hash_watch_stripes = 64
+
+/*
+ The size of the event buffer of a HashWatcher if WatchOptions.Buffer is 0.
+*/
+const default_watch_buffer = 64
+
+/*
+ HashOp is the kind of change a HashEvent describes.
+*/
+type HashOp int
+
+const (
+	HashPut HashOp = iota
+	HashDelete
+	/*
+	 HashClear events have no key or values, and are sent to all HashWatchers when Clear is called.
+	*/
+	HashClear
+)
+
+func (self HashOp) String() string {
+	switch self {
+	case HashPut:
+		return "put"
+	case HashDelete:
+		return "delete"
+	case HashClear:
+		return "clear"
+	}
+	return fmt.Sprintf("HashOp(%d)", int(self))
+}
+
+/*
+ HashEvent describes a change to a Hash.
+*/
+type HashEvent struct {
+	Op  HashOp
+	Key Hashable
+	/*
+	 Old is the value that was replaced or deleted, and Existed whether there was one.
+	*/
+	Old     Thing
+	Existed bool
+	/*
+	 New is the value that was put, or nil for deletes.
+	*/
+	New Thing
+}
+
+func (self HashEvent) String() string {
+	return fmt.Sprintf("%v %v: %v (%v) => %v", self.Op, self.Key, self.Old, self.Existed, self.New)
+}
+
+/*
+ WatchOverflow decides what a HashWatcher does when its buffer is full.
+*/
+type WatchOverflow int
+
+const (
+	/*
+	 WatchBlock makes writers to the Hash wait until the buffer has room.
+
+	 Since writers wait while holding the lock for their key, whatever consumes the events must not write to the
+	 watched Hash, or it may end up waiting for itself to make room in the buffer. Use WatchDropNewest or
+	 WatchDropOldest if it has to.
+	*/
+	WatchBlock WatchOverflow = iota
+	/*
+	 WatchDropNewest drops the event that didn't fit.
+	*/
+	WatchDropNewest
+	/*
+	 WatchDropOldest drops the oldest event in the buffer to make room for the new one.
+	*/
+	WatchDropOldest
+)
+
+/*
+ WatchOptions configures a HashWatcher.
+*/
+type WatchOptions struct {
+	/*
+	 If Prefix is not empty, only changes to StringKeys, or keys implementing fmt.Stringer, starting with Prefix are delivered.
+	*/
+	Prefix string
+	/*
+	 Buffer is the number of events buffered before Overflow decides what to do. If 0 default_watch_buffer is used.
+	*/
+	Buffer   int
+	Overflow WatchOverflow
+}
+
+/*
+ HashWatcher receives the changes made to a Hash.
+*/
+type HashWatcher struct {
+	hash    *Hash
+	options WatchOptions
+	events  chan HashEvent
+	done    chan bool
+	dropped int64
+	closed  int32
+}
+
+/*
+ hashWatch is what a watched Hash uses to keep the events of each key in order.
+
+ While there are HashWatchers, writers lock the stripe of the hash code they write to while changing the Hash and
+ notifying the HashWatchers, so the events for a key are delivered in the order the changes took effect. Events for different keys are not
+ ordered relative each other.
+*/
+type hashWatch struct {
+	stripes [hash_watch_stripes]sync.Mutex
+	/*
+	 Protects changes to watchers.
+	*/
+	lock sync.Mutex
+	/*
+	 Will point to a []*HashWatcher that is replaced, never changed, when watchers are added or removed.
+	*/
+	watchers unsafe.Pointer
+}
+
+func (self *Hash) getWatch() *hashWatch {
+	return (*hashWatch)(atomic.LoadPointer(&self.watch))
+}
+
+/*
+ getWatchedBy returns the hashWatch if the Hash has any HashWatchers, and nil otherwise.
+*/
+func (self *Hash) getWatchedBy() *hashWatch {
+	if w := self.getWatch(); w != nil && len(w.getWatchers()) > 0 {
+		return w
+	}
+	return nil
+}
+
+/*
+ lockWatch locks the stripe of hashCode and returns the hashWatch if the Hash has any HashWatchers, and returns nil otherwise.
+*/
+func (self *Hash) lockWatch(hashCode uint32) *hashWatch {
+	w := self.getWatchedBy()
+	if w != nil {
+		w.stripes[hashCode%hash_watch_stripes].Lock()
+	}
+	return w
+}
+func (self *hashWatch) unlock(hashCode uint32) {
+	if self != nil {
+		self.stripes[hashCode%hash_watch_stripes].Unlock()
+	}
+}
+
+/*
+ lockWatchAll locks all stripes and returns the hashWatch if the Hash has any HashWatchers, and returns nil otherwise.
+*/
+func (self *Hash) lockWatchAll() *hashWatch {
+	w := self.getWatchedBy()
+	w.lockAll()
+	return w
+}
+func (self *hashWatch) lockAll() {
+	if self != nil {
+		for index := range self.stripes {
+			self.stripes[index].Lock()
+		}
+	}
+}
+func (self *hashWatch) unlockAll() {
+	if self != nil {
+		for index := range self.stripes {
+			self.stripes[index].Unlock()
+		}
+	}
+}
+func (self *hashWatch) getWatchers() []*HashWatcher {
+	return *(*[]*HashWatcher)(atomic.LoadPointer(&self.watchers))
+}
+
+/*
+ notify sends e to all matching HashWatchers, if there is a hashWatch. The stripe of the key must be locked.
+*/
+func (self *hashWatch) notify(e HashEvent) {
+	if self == nil {
+		return
+	}
+	for _, watcher := range self.getWatchers() {
+		if watcher.matches(e.Key) {
+			watcher.send(e)
+		}
+	}
+}
+
+/*
+ Watch returns a HashWatcher that will receive all changes made to the Hash from now on, in the order they
+ took effect for each key, until it is closed.
+
+ Writers to a watched Hash have to take a lock per key, so a Hash is not non blocking while it has HashWatchers.
+ Changes made while Watch is called on a Hash without other HashWatchers may be missing, so to mirror a Hash either
+ Watch it before it is shared, or Watch it before copying its contents while it isn't changed.
+*/
+func (self *Hash) Watch(options WatchOptions) *HashWatcher {
+	if options.Buffer == 0 {
+		options.Buffer = default_watch_buffer
+	}
+	rval := &HashWatcher{
+		hash:    self,
+		options: options,
+		events:  make(chan HashEvent, options.Buffer),
+		done:    make(chan bool),
+	}
+	w := self.getWatch()
+	if w == nil {
+		w = &hashWatch{}
+		empty := []*HashWatcher{}
+		w.watchers = unsafe.Pointer(&empty)
+		if !atomic.CompareAndSwapPointer(&self.watch, nil, unsafe.Pointer(w)) {
+			w = self.getWatch()
+		}
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	old := w.getWatchers()
+	watchers := make([]*HashWatcher, len(old), len(old)+1)
+	copy(watchers, old)
+	watchers = append(watchers, rval)
+	atomic.StorePointer(&w.watchers, unsafe.Pointer(&watchers))
+	return rval
+}
+
+/*
+ WatchFunc runs f with each change made to the Hash from now on, in a separate goroutine, until the returned HashWatcher is closed.
+
+ f may still be running with buffered changes after Close returns.
+
+ With WatchBlock f must not write to the Hash, since it could deadlock waiting for its own buffer to have room.
+*/
+func (self *Hash) WatchFunc(options WatchOptions, f func(e HashEvent)) *HashWatcher {
+	rval := self.Watch(options)
+	go func() {
+		for e := range rval.events {
+			f(e)
+		}
+	}()
+	return rval
+}
+
+func (self *HashWatcher) String() string {
+	return fmt.Sprintf("&HashWatcher{%p buffered:%v dropped:%v}", self, len(self.events), self.Dropped())
+}
+
+/*
+ Events returns the channel the changes are delivered on. It is closed when the HashWatcher is closed.
+*/
+func (self *HashWatcher) Events() <-chan HashEvent {
+	return self.events
+}
+
+/*
+ Dropped returns the number of events dropped because the buffer was full.
+
+ If it is not 0, anything mirroring the Hash using this HashWatcher has missed changes.
+*/
+func (self *HashWatcher) Dropped() int {
+	return int(atomic.LoadInt64(&self.dropped))
+}
+
+func (self *HashWatcher) matches(k Hashable) bool {
+	if self.options.Prefix == "" || k == nil {
+		return true
+	}
+	switch key := k.(type) {
+	case StringKey:
+		return strings.HasPrefix(string(key), self.options.Prefix)
+	case fmt.Stringer:
+		return strings.HasPrefix(key.String(), self.options.Prefix)
+	}
+	return false
+}
+
+func (self *HashWatcher) send(e HashEvent) {
+	switch self.options.Overflow {
+	case WatchBlock:
+		select {
+		case self.events <- e:
+		case <-self.done:
+		}
+	case WatchDropNewest:
+		select {
+		case self.events <- e:
+		default:
+			atomic.AddInt64(&self.dropped, 1)
+		}
+	case WatchDropOldest:
+		for {
+			select {
+			case self.events <- e:
+				return
+			default:
+			}
+			select {
+			case <-self.events:
+				atomic.AddInt64(&self.dropped, 1)
+			default:
+			}
+		}
+	}
+}
+
+/*
+ Close stops the HashWatcher from receiving any more changes, and closes its channel once all writers notifying it are done.
+*/
+func (self *HashWatcher) Close() {
+	if !atomic.CompareAndSwapInt32(&self.closed, 0, 1) {
+		return
+	}
+	w := self.hash.getWatch()
+	w.lock.Lock()
+	old := w.getWatchers()
+	watchers := make([]*HashWatcher, 0, len(old))
+	for _, watcher := range old {
+		if watcher != self {
+			watchers = append(watchers, watcher)
+		}
+	}
+	atomic.StorePointer(&w.watchers, unsafe.Pointer(&watchers))
+	w.lock.Unlock()
+	/*
+	 Release writers blocked on a full buffer, and wait for all writers that may still see us to finish.
+	*/
+	close(self.done)
+	w.lockAll()
+	w.unlockAll()
+	close(self.events)
+}
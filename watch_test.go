@@ -0,0 +1,170 @@
+package gotomic
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func assertEvents(t *testing.T, w *HashWatcher, expected ...HashEvent) {
+	for _, e := range expected {
+		select {
+		case got := <-w.Events():
+			if !reflect.DeepEqual(got, e) {
+				t.Errorf("%v should have delivered %v, but delivered %v", w, e, got)
+			}
+		default:
+			t.Errorf("%v should have delivered %v, but had nothing", w, e)
+		}
+	}
+	select {
+	case got := <-w.Events():
+		t.Errorf("%v should have delivered nothing more, but delivered %v", w, got)
+	default:
+	}
+}
+
+func TestHashWatch(t *testing.T) {
+	h := NewHash()
+	h.Put(StringKey("before"), 0)
+	w := h.Watch(WatchOptions{})
+	h.Put(StringKey("a"), 1)
+	h.Put(StringKey("a"), 2)
+	h.PutIfMissing(StringKey("a"), 3)
+	h.PutIfMissing(StringKey("b"), IntKey(4))
+	h.PutIfPresent(StringKey("b"), 5, IntKey(0))
+	h.PutIfPresent(StringKey("b"), 5, IntKey(4))
+	h.Delete(StringKey("c"))
+	h.Delete(StringKey("a"))
+	h.Clear()
+	assertEvents(t, w,
+		HashEvent{HashPut, StringKey("a"), nil, false, 1},
+		HashEvent{HashPut, StringKey("a"), 1, true, 2},
+		HashEvent{HashPut, StringKey("b"), nil, false, IntKey(4)},
+		HashEvent{HashPut, StringKey("b"), IntKey(4), true, 5},
+		HashEvent{HashDelete, StringKey("a"), 2, true, nil},
+		HashEvent{HashClear, nil, nil, false, nil},
+	)
+	w.Close()
+	h.Put(StringKey("a"), 1)
+	if _, ok := <-w.Events(); ok {
+		t.Error(w, "should be closed")
+	}
+	/*
+	 Without HashWatchers writers shouldn't lock anything.
+	*/
+	h.getWatch().lockAll()
+	defer h.getWatch().unlockAll()
+	done := make(chan bool)
+	go func() {
+		h.Put(StringKey("a"), 2)
+		h.Delete(StringKey("a"))
+		h.Clear()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error(h, "should not lock anything after all HashWatchers are closed")
+	}
+}
+
+func TestHashWatchPrefix(t *testing.T) {
+	h := NewHash()
+	w := h.Watch(WatchOptions{Prefix: "user/"})
+	h.Put(StringKey("user/1"), 1)
+	h.Put(StringKey("group/1"), 2)
+	h.Put(hashInt(1), 3)
+	h.Delete(StringKey("user/1"))
+	assertEvents(t, w,
+		HashEvent{HashPut, StringKey("user/1"), nil, false, 1},
+		HashEvent{HashDelete, StringKey("user/1"), 1, true, nil},
+	)
+	w.Close()
+}
+
+func TestHashWatchOverflow(t *testing.T) {
+	h := NewHash()
+	newest := h.Watch(WatchOptions{Buffer: 2, Overflow: WatchDropNewest})
+	oldest := h.Watch(WatchOptions{Buffer: 2, Overflow: WatchDropOldest})
+	for i := 0; i < 5; i++ {
+		h.Put(hashInt(i), i)
+	}
+	assertEvents(t, newest,
+		HashEvent{HashPut, hashInt(0), nil, false, 0},
+		HashEvent{HashPut, hashInt(1), nil, false, 1},
+	)
+	assertEvents(t, oldest,
+		HashEvent{HashPut, hashInt(3), nil, false, 3},
+		HashEvent{HashPut, hashInt(4), nil, false, 4},
+	)
+	if newest.Dropped() != 3 || oldest.Dropped() != 3 {
+		t.Error(newest, oldest, "should have dropped 3 events each")
+	}
+	blocking := h.Watch(WatchOptions{Buffer: 1})
+	h.Put(hashInt(0), 0)
+	done := make(chan bool)
+	go func() {
+		h.Put(hashInt(1), 1)
+		done <- true
+	}()
+	blocking.Close()
+	<-done
+	newest.Close()
+	oldest.Close()
+}
+
+func fiddleWatchedHash(h *Hash, x int, do chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+	<-do
+	for i := 0; i < 1000; i++ {
+		k := hashInt(i % 37)
+		switch i % 3 {
+		case 0:
+			h.Put(k, i*x)
+		case 1:
+			h.Delete(k)
+		case 2:
+			h.PutIfMissing(k, -i*x)
+		}
+	}
+}
+
+func TestHashWatchConc(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	h := NewHash()
+	mirror := make(map[Hashable]Thing)
+	mirrored := make(chan bool)
+	w := h.WatchFunc(WatchOptions{}, func(e HashEvent) {
+		if e.Key == hashInt(-1) {
+			close(mirrored)
+			return
+		}
+		switch e.Op {
+		case HashPut:
+			if old, ok := mirror[e.Key]; ok != e.Existed || (ok && old != e.Old) {
+				t.Errorf("%v should have replaced %v (%v) in the mirror", e, old, ok)
+			}
+			mirror[e.Key] = e.New
+		case HashDelete:
+			delete(mirror, e.Key)
+		}
+	})
+	var wg sync.WaitGroup
+	do := make(chan bool)
+	for i := 1; i <= runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go fiddleWatchedHash(h, i, do, &wg)
+	}
+	close(do)
+	wg.Wait()
+	h.Put(hashInt(-1), nil)
+	<-mirrored
+	w.Close()
+	h.Delete(hashInt(-1))
+	if !reflect.DeepEqual(mirror, h.ToMap()) {
+		t.Errorf("%v should equal %v", mirror, h.ToMap())
+	}
+}